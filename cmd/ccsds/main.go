@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 )
 
 func main() {
+	cadu := flag.Bool("v", false, "read packets from CADUs")
 	flag.Parse()
 
 	r, err := os.Open(flag.Arg(0))
@@ -21,29 +23,29 @@ func main() {
 	}
 	defer r.Close()
 
-	buffer := make([]byte, pathtm.CCSDSHeaderLen)
+	framing := pathtm.FrameCCSDS
+	if *cadu {
+		framing = pathtm.FrameCADU
+	}
+	d := pathtm.NewFramedDecoder(r, framing, nil)
 	digest := xxh.New64(0)
 
-	d := Dump()
-	for i := 1; ; i++ {
-		_, err := r.Read(buffer)
-		switch err {
-		case nil:
+	dump := Dump()
+	for i := 0; ; i++ {
+		p, err := d.Decode(true)
+		switch {
+		case err == nil:
 			digest.Reset()
-
-			c, err := pathtm.DecodeCCSDS(buffer)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(3)
+			if buf, err := p.Marshal(); err == nil {
+				digest.Write(buf[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:])
 			}
-			if _, err := io.CopyN(digest, r, int64(c.Length)); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(2)
-			}
-			d.Dump(c, digest.Sum64())
-		case io.EOF:
+			dump.Dump(p.CCSDSHeader, digest.Sum64())
+		case err == io.EOF:
 			fmt.Fprintf(os.Stdout, "%d packets\n", i)
 			return
+		case errors.Is(err, pathtm.ErrVersion):
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
 		default:
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+	compressXz   = "xz"
+)
+
+var (
+	magicGzip = []byte{0x1f, 0x8b, 0x08}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicXz   = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+)
+
+// compression gives the compression of a file from its extension or, when the
+// extension is not known, from the first bytes of the file. None of the magic
+// bytes can be the start of a PTH packet.
+func compression(file string, r *bufio.Reader) string {
+	switch filepath.Ext(file) {
+	case ".gz":
+		return compressGzip
+	case ".zst":
+		return compressZstd
+	case ".xz":
+		return compressXz
+	}
+	magic, _ := r.Peek(len(magicXz))
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		return compressGzip
+	case bytes.HasPrefix(magic, magicZstd):
+		return compressZstd
+	case bytes.HasPrefix(magic, magicXz):
+		return compressXz
+	default:
+		return ""
+	}
+}
+
+// decompressedFile gives the decompressed bytes of a file.
+type decompressedFile struct {
+	io.Reader
+	file   *os.File
+	closer io.Closer
+	// compression of the file, empty if the file is not compressed
+	method string
+}
+
+// openFile opens file and decompresses it if it is compressed with gzip, zstd
+// or xz.
+func openFile(file string) (decompressedFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return decompressedFile{}, err
+	}
+	var (
+		r = bufio.NewReaderSize(f, 64<<10)
+		d = decompressedFile{file: f}
+	)
+	d.method = compression(file, r)
+	switch d.method {
+	case compressGzip:
+		var z *gzip.Reader
+		if z, err = gzip.NewReader(r); err == nil {
+			d.Reader, d.closer = z, z
+		}
+	case compressZstd:
+		var z *zstd.Decoder
+		if z, err = zstd.NewReader(r); err == nil {
+			rc := z.IOReadCloser()
+			d.Reader, d.closer = rc, rc
+		}
+	case compressXz:
+		d.Reader, err = xz.NewReader(r)
+	default:
+		d.Reader = r
+	}
+	if err != nil {
+		f.Close()
+		return decompressedFile{}, fmt.Errorf("%s: %s", file, err)
+	}
+	return d, nil
+}
+
+func (d decompressedFile) Name() string {
+	return d.file.Name()
+}
+
+func (d decompressedFile) Close() error {
+	var err error
+	if d.closer != nil {
+		err = d.closer.Close()
+	}
+	if e := d.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// decompressFiles gives the names of files once decompressed: rt.MergeFiles
+// reads the files by name so the compressed ones are decompressed into
+// temporary files. The returned function removes them.
+func decompressFiles(files []string) ([]string, func(), error) {
+	var (
+		names = make([]string, 0, len(files))
+		temps []string
+	)
+	clean := func() {
+		for _, t := range temps {
+			os.Remove(t)
+		}
+	}
+	for _, file := range files {
+		f, err := openFile(file)
+		if err != nil {
+			clean()
+			return nil, nil, err
+		}
+		if f.method == "" {
+			f.Close()
+			names = append(names, file)
+			continue
+		}
+		t, err := os.CreateTemp("", "tmcat-*")
+		if err == nil {
+			temps = append(temps, t.Name())
+			_, err = io.Copy(t, f)
+			if e := t.Close(); err == nil {
+				err = e
+			}
+		}
+		f.Close()
+		if err != nil {
+			clean()
+			return nil, nil, fmt.Errorf("%s: %s", file, err)
+		}
+		names = append(names, t.Name())
+	}
+	return names, clean, nil
+}
+
+// fileList reads the files given to a command one after the other,
+// decompressing them when needed. It replaces rt.Browse that only reads raw
+// files.
+type fileList struct {
+	files   []string
+	current io.ReadCloser
+	// name of the file of the last bytes read
+	name string
+}
+
+// Browse gives a reader of the files found in the given files and directories.
+func Browse(paths []string) (io.ReadCloser, error) {
+	fs, err := walkFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	return &fileList{files: fs}, nil
+}
+
+func (f *fileList) Read(b []byte) (int, error) {
+	for {
+		if f.current == nil {
+			if len(f.files) == 0 {
+				return 0, io.EOF
+			}
+			r, err := openFile(f.files[0])
+			if err != nil {
+				return 0, err
+			}
+			f.current, f.files, f.name = r, f.files[1:], r.Name()
+		}
+		n, err := f.current.Read(b)
+		if err == io.EOF {
+			err = f.current.Close()
+			f.current = nil
+			if n == 0 && err == nil {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+// Name gives the name of the file of the last bytes read. It is kept once
+// the file has been closed so that the bytes read at its end are reported
+// with it.
+func (f *fileList) Name() string {
+	return f.name
+}
+
+func (f *fileList) Close() error {
+	if f.current == nil {
+		return nil
+	}
+	err := f.current.Close()
+	f.current, f.files = nil, nil
+	return err
+}
+
+// compressor compresses the bytes written to a file of the archive and counts
+// them before and after compression.
+type compressor struct {
+	io.Writer
+	raw    *counter
+	packed *counter
+	closer io.Closer
+}
+
+func compress(method string, w io.Writer) (*compressor, error) {
+	c := compressor{packed: &counter{Writer: w}}
+	switch method {
+	case compressGzip:
+		z := gzip.NewWriter(c.packed)
+		c.raw, c.closer = &counter{Writer: z}, z
+	case compressZstd:
+		z, err := zstd.NewWriter(c.packed)
+		if err != nil {
+			return nil, err
+		}
+		c.raw, c.closer = &counter{Writer: z}, z
+	case "":
+		c.raw = c.packed
+	default:
+		return nil, fmt.Errorf("invalid compression: %s", method)
+	}
+	c.Writer = c.raw
+	return &c, nil
+}
+
+func (c *compressor) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// extension gives the extension added to the name of the files compressed with
+// method.
+func extension(method string) string {
+	switch method {
+	case compressGzip:
+		return ".gz"
+	case compressZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+type counter struct {
+	io.Writer
+	count int64
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	n, err := c.Writer.Write(b)
+	c.count += int64(n)
+	return n, err
+}
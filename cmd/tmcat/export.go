@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/midbel/cli"
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupSize is the maximum number of rows buffered for a partition (apid
+// and day) before being written as a row group.
+const rowGroupSize = 1 << 16
+
+// maxBuffered is the maximum number of payload bytes buffered for all the
+// partitions. Past it, all the partitions are written even if incomplete.
+const maxBuffered = 64 << 20
+
+type packetRow struct {
+	PTHSize       uint32    `parquet:"pth_size"`
+	PTHType       uint8     `parquet:"pth_type"`
+	PTHCoarse     uint32    `parquet:"pth_coarse"`
+	PTHFine       uint8     `parquet:"pth_fine"`
+	PTHTime       time.Time `parquet:"pth_time"`
+	CCSDSPid      uint16    `parquet:"ccsds_pid"`
+	CCSDSFragment uint16    `parquet:"ccsds_fragment"`
+	CCSDSLength   uint16    `parquet:"ccsds_length"`
+	ESACoarse     uint32    `parquet:"esa_coarse"`
+	ESAFine       uint8     `parquet:"esa_fine"`
+	ESASid        uint32    `parquet:"esa_sid"`
+	ESAInfo       uint8     `parquet:"esa_info"`
+	ESATime       time.Time `parquet:"esa_time"`
+	Apid          uint16    `parquet:"apid"`
+	Sequence      uint16    `parquet:"sequence"`
+	Segmentation  string    `parquet:"segmentation,dict"`
+	PacketType    string    `parquet:"packet_type,dict"`
+	Payload       []byte    `parquet:"payload,optional"`
+}
+
+func newPacketRow(p pathtm.Packet, data bool) packetRow {
+	r := packetRow{
+		PTHSize:       p.PTHHeader.Size,
+		PTHType:       p.PTHHeader.Type,
+		PTHCoarse:     p.PTHHeader.Coarse,
+		PTHFine:       p.PTHHeader.Fine,
+		PTHTime:       p.PTHHeader.Timestamp(),
+		CCSDSPid:      p.Pid,
+		CCSDSFragment: p.Fragment,
+		CCSDSLength:   p.Length,
+		ESACoarse:     p.ESAHeader.Coarse,
+		ESAFine:       p.ESAHeader.Fine,
+		ESASid:        p.Sid,
+		ESAInfo:       p.Info,
+		ESATime:       p.Timestamp(),
+		Apid:          p.Apid(),
+		Sequence:      p.Sequence(),
+		Segmentation:  p.Segmentation().String(),
+		PacketType:    p.PacketType().String(),
+	}
+	if data {
+		r.Payload = p.Data
+	}
+	return r
+}
+
+type partition struct {
+	Pid uint16
+	Day time.Time
+}
+
+func runExport(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	format := cmd.Flag.String("o", "parquet", "output format")
+	data := cmd.Flag.Bool("d", false, "include packet payload")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if *format != "parquet" {
+		return fmt.Errorf("invalid output format: %s", *format)
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	mr, err := Browse(cmd.Flag.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	w, err := os.Create(cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	d := pathtm.NewDecoder(mr, filter)
+	if err := exportParquet(d, w, *data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// exportParquet writes one row per packet. Rows are buffered by apid and day so
+// that each row group only holds the packets of one partition. The partitions
+// of a day are written once packets of a later day are found, when a partition
+// has rowGroupSize rows or when the buffered payloads exceed maxBuffered bytes.
+func exportParquet(d *pathtm.Decoder, w io.Writer, data bool) error {
+	var (
+		pw    = parquet.NewGenericWriter[packetRow](w, parquet.Compression(&parquet.Snappy))
+		parts = make(map[partition][]packetRow)
+		size  int
+	)
+	flush := func(k partition) error {
+		rs := parts[k]
+		delete(parts, k)
+		for _, r := range rs {
+			size -= len(r.Payload)
+		}
+		if _, err := pw.Write(rs); err != nil {
+			return err
+		}
+		return pw.Flush()
+	}
+	flushBefore := func(day time.Time) error {
+		ks := make([]partition, 0, len(parts))
+		for k := range parts {
+			if day.IsZero() || k.Day.Before(day) {
+				ks = append(ks, k)
+			}
+		}
+		sort.Slice(ks, func(i, j int) bool {
+			if ks[i].Pid == ks[j].Pid {
+				return ks[i].Day.Before(ks[j].Day)
+			}
+			return ks[i].Pid < ks[j].Pid
+		})
+		for _, k := range ks {
+			if err := flush(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var last time.Time
+	for {
+		p, err := d.Decode(data)
+		if err == io.EOF {
+			break
+		}
+		if skipPacket(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		k := partition{
+			Pid: p.Apid(),
+			Day: p.Timestamp().Truncate(time.Hour * 24),
+		}
+		if k.Day.After(last) {
+			if err := flushBefore(k.Day); err != nil {
+				return err
+			}
+			last = k.Day
+		}
+		r := newPacketRow(p, data)
+		parts[k] = append(parts[k], r)
+		size += len(r.Payload)
+
+		switch {
+		case len(parts[k]) >= rowGroupSize:
+			err = flush(k)
+		case size >= maxBuffered:
+			err = flushBefore(time.Time{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := flushBefore(time.Time{}); err != nil {
+		return err
+	}
+	return pw.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/midbel/linewriter"
+)
+
+const (
+	formatText   = "text"
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// Output registers the -o flag (and the -c flag kept for compatibility)
+// selecting the format of the records printed by a command.
+type Output struct {
+	Format string
+	CSV    bool
+}
+
+func (o *Output) Register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "o", "", "output format (text, csv, json, ndjson)")
+	fs.BoolVar(&o.CSV, "c", false, "csv format")
+}
+
+func (o *Output) Printer(w io.Writer) (*Printer, error) {
+	format := o.Format
+	if format == "" {
+		format = formatText
+		if o.CSV {
+			format = formatCSV
+		}
+	}
+	p := Printer{
+		format: format,
+		inner:  w,
+	}
+	switch format {
+	case formatText, formatCSV:
+		p.line = Line(format == formatCSV)
+	case formatJSON, formatNDJSON:
+	default:
+		return nil, fmt.Errorf("invalid output format: %s", format)
+	}
+	return &p, nil
+}
+
+// Printer writes records either as lines built with a linewriter (text and
+// csv) or as JSON documents. With json, all the records are written in one
+// array and Close should be called once the last record has been printed.
+type Printer struct {
+	format string
+	inner  io.Writer
+	line   *linewriter.Writer
+	count  int
+}
+
+// CSV reports whether the records are printed as csv.
+func (p *Printer) CSV() bool {
+	return p.format == formatCSV
+}
+
+// Print writes rec as JSON or gives the linewriter of p to fill so that the
+// line can be written.
+func (p *Printer) Print(rec interface{}, fill func(*linewriter.Writer)) error {
+	if p.line != nil {
+		fill(p.line)
+		_, err := io.Copy(p.inner, p.line)
+		return err
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.format == formatNDJSON:
+	case p.count == 0:
+		buf = append([]byte("[\n"), buf...)
+	default:
+		buf = append([]byte(",\n"), buf...)
+	}
+	if p.format == formatNDJSON {
+		buf = append(buf, '\n')
+	}
+	p.count++
+	_, err = p.inner.Write(buf)
+	return err
+}
+
+func (p *Printer) Close() error {
+	if p.format != formatJSON {
+		return nil
+	}
+	str := "\n]\n"
+	if p.count == 0 {
+		str = "[]\n"
+	}
+	_, err := io.WriteString(p.inner, str)
+	return err
+}
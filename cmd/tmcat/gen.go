@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/busoc/pathtm/gen"
+	"github.com/midbel/cli"
+)
+
+func runGen(cmd *cli.Command, args []string) error {
+	var (
+		sources Sources
+		start   Time
+	)
+	cmd.Flag.Var(&sources, "s", "source (apid:sid:type:interval:size[-max]:segments:sequence)")
+	cmd.Flag.Var(&start, "t", "time of the first packet")
+	count := cmd.Flag.Int("n", 1000, "number of packets")
+	seed := cmd.Flag.Int64("seed", 1, "seed")
+	faults := []struct {
+		gen.Fault
+		prob *float64
+	}{
+		{Fault: gen.FaultGap, prob: cmd.Flag.Float64("gap", 0, "probability of sequence gaps")},
+		{Fault: gen.FaultDuplicate, prob: cmd.Flag.Float64("dup", 0, "probability of duplicated packets")},
+		{Fault: gen.FaultWrap, prob: cmd.Flag.Float64("wrap", 0, "probability of sequence counter wraps")},
+		{Fault: gen.FaultVersion, prob: cmd.Flag.Float64("version", 0, "probability of invalid CCSDS versions")},
+		{Fault: gen.FaultTruncate, prob: cmd.Flag.Float64("truncate", 0, "probability of truncated packets")},
+		{Fault: gen.FaultJump, prob: cmd.Flag.Float64("jump", 0, "probability of time jumps")},
+		{Fault: gen.FaultReset, prob: cmd.Flag.Float64("reset", 0, "probability of sequence counter resets")},
+	}
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no source given")
+	}
+	if start.IsZero() {
+		start.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	g := gen.New(*seed, start.Time, sources...)
+	for _, f := range faults {
+		g.Inject(f.Fault, *f.prob)
+	}
+
+	var w io.Writer = os.Stdout
+	if file := cmd.Flag.Arg(0); file != "" && file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	ws := bufio.NewWriterSize(w, pathtm.BufferSize)
+	if err := g.Generate(ws, *count); err != nil {
+		return err
+	}
+	return ws.Flush()
+}
+
+// Sources is a list of gen.Source given as
+// apid:sid:type:interval:size[-max]:segments:sequence where all fields but the
+// apid are optional.
+type Sources []gen.Source
+
+func (s *Sources) String() string {
+	vs := make([]string, len(*s))
+	for i, g := range *s {
+		vs[i] = fmt.Sprintf("%d:%d:%d:%s:%d-%d:%d:%d", g.Apid, g.Sid, g.Type, g.Interval, g.Size, g.MaxSize, g.Segments, g.Sequence)
+	}
+	return strings.Join(vs, ",")
+}
+
+func (s *Sources) Set(str string) error {
+	var (
+		g  gen.Source
+		vs = strings.Split(str, ":")
+	)
+	for i, v := range vs {
+		if v == "" {
+			continue
+		}
+		var err error
+		switch i {
+		case 0:
+			g.Apid, err = parseUint16(v)
+		case 1:
+			var sid uint64
+			sid, err = strconv.ParseUint(v, 0, 32)
+			g.Sid = uint32(sid)
+		case 2:
+			var t uint64
+			t, err = strconv.ParseUint(v, 0, 4)
+			g.Type = pathtm.ESAPacketType(t)
+		case 3:
+			g.Interval, err = time.ParseDuration(v)
+		case 4:
+			min, max := v, ""
+			if x := strings.Index(v, "-"); x > 0 {
+				min, max = v[:x], v[x+1:]
+			}
+			if g.Size, err = strconv.Atoi(min); err == nil && max != "" {
+				g.MaxSize, err = strconv.Atoi(max)
+			}
+		case 5:
+			g.Segments, err = strconv.Atoi(v)
+		case 6:
+			g.Sequence, err = parseUint16(v)
+		default:
+			err = fmt.Errorf("too many fields")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid source %s: %s", str, err)
+		}
+	}
+	*s = append(*s, g)
+	return nil
+}
+
+func parseUint16(str string) (uint16, error) {
+	v, err := strconv.ParseUint(str, 0, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/midbel/cli"
+	"github.com/midbel/xxh"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const indexSchema = `
+create table if not exists packets(
+	file text not null,
+	position integer not null,
+	length integer not null,
+	apid integer not null,
+	sid integer not null,
+	sequence integer not null,
+	esa_time integer not null,
+	pth_time integer not null,
+	hash integer not null,
+	primary key(file, position)
+);
+create index if not exists packets_esa on packets(apid, esa_time);
+create index if not exists packets_pth on packets(pth_time);
+create table if not exists files(
+	file text not null primary key,
+	size integer not null,
+	mtime integer not null,
+	hash integer not null
+);
+`
+
+const insertPacket = `insert into packets(file, position, length, apid, sid, sequence, esa_time, pth_time, hash) values(?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// errCompressed is given by indexFile for the compressed files: the positions
+// recorded are the ones of the packets in the file as it is and can not be
+// used to read a compressed file.
+var errCompressed = errors.New("compressed file can not be indexed")
+
+func runIndex(cmd *cli.Command, args []string) error {
+	update := cmd.Flag.Bool("update", false, "only index new or modified files")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	db, err := openIndex(cmd.Flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// the files are recorded with their absolute path so that the index can
+	// be used from any directory.
+	paths := cmd.Flag.Args()[1:]
+	for i, p := range paths {
+		if paths[i], err = filepath.Abs(p); err != nil {
+			return err
+		}
+	}
+	files, err := walkFiles(paths)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		s, err := statFile(f)
+		if err != nil {
+			return err
+		}
+		if *update {
+			ok, err := isIndexed(db, &s)
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+		}
+		switch err := indexFile(db, s); err {
+		case nil:
+		case errCompressed:
+			fmt.Fprintf(os.Stderr, "%s: %s: skipped\n", f, err)
+		default:
+			return err
+		}
+	}
+	if *update {
+		return dropDeleted(db, paths, files)
+	}
+	return nil
+}
+
+// fileState is what is recorded in the index about each file to know whether
+// the file has changed since it has been indexed.
+type fileState struct {
+	File  string
+	Size  int64
+	Mtime int64
+	Hash  uint64
+}
+
+func statFile(file string) (fileState, error) {
+	s := fileState{File: file}
+	i, err := os.Stat(file)
+	if err == nil {
+		s.Size, s.Mtime = i.Size(), i.ModTime().UnixNano()
+	}
+	return s, err
+}
+
+// digest computes the hash of the content of the file if not yet done.
+func (s *fileState) digest() error {
+	if s.Hash != 0 {
+		return nil
+	}
+	r, err := os.Open(s.File)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	digest := xxh.New64(0)
+	if _, err := io.Copy(digest, r); err != nil {
+		return err
+	}
+	s.Hash = digest.Sum64()
+	return nil
+}
+
+// isIndexed reports whether the file has already been indexed in its current
+// state. The content of the file is only hashed when its size or its
+// modification time has changed. When only its modification time has changed,
+// the new time is recorded.
+func isIndexed(db *sql.DB, s *fileState) (bool, error) {
+	var (
+		other fileState
+		hash  int64
+	)
+	row := db.QueryRow("select size, mtime, hash from files where file=?", s.File)
+	switch err := row.Scan(&other.Size, &other.Mtime, &hash); err {
+	case nil:
+	case sql.ErrNoRows:
+		return false, nil
+	default:
+		return false, err
+	}
+	if other.Size == s.Size && other.Mtime == s.Mtime {
+		return true, nil
+	}
+	if err := s.digest(); err != nil || other.Size != s.Size || uint64(hash) != s.Hash {
+		return false, err
+	}
+	_, err := db.Exec("update files set mtime=? where file=?", s.Mtime, s.File)
+	return err == nil, err
+}
+
+// dropDeleted removes from the index the files located under the given paths
+// that do not exist anymore.
+func dropDeleted(db *sql.DB, paths, files []string) error {
+	seen := make(map[string]struct{})
+	for _, f := range files {
+		seen[f] = struct{}{}
+	}
+	rows, err := db.Query("select file from files")
+	if err != nil {
+		return err
+	}
+	var deleted []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := seen[file]; !ok && isUnder(file, paths) {
+			deleted = append(deleted, file)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, f := range deleted {
+		if _, err := db.Exec("delete from packets where file=?", f); err != nil {
+			return err
+		}
+		if _, err := db.Exec("delete from files where file=?", f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isUnder(file string, paths []string) bool {
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if file == p || strings.HasPrefix(file, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func openIndex(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(indexSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// indexFile replaces the entries of file in the index by the packets found in
+// it and records its current state.
+func indexFile(db *sql.DB, s fileState) error {
+	file := s.File
+	r, err := openFile(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if r.method != "" {
+		return errCompressed
+	}
+	if err := s.digest(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("delete from packets where file=?", file); err != nil {
+		return err
+	}
+	_, err = tx.Exec("insert or replace into files(file, size, mtime, hash) values(?, ?, ?, ?)", file, s.Size, s.Mtime, int64(s.Hash))
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(insertPacket)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	d := pathtm.NewDecoder(r, nil)
+	for {
+		p, err := d.Decode(true)
+		if err == io.EOF {
+			break
+		}
+		if skipPacket(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var sum uint64
+		if buf, err := p.Marshal(); err == nil {
+			sum = xxh.Sum64(buf[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:], 0)
+		}
+		_, err = stmt.Exec(
+			file,
+			d.Offset(),
+			int(p.PTHHeader.Size)+4,
+			p.Apid(),
+			p.Sid,
+			p.Sequence(),
+			p.Timestamp().UnixNano(),
+			p.PTHHeader.Timestamp().UnixNano(),
+			int64(sum),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queryIndex gives the entries of the packets having one of the given apids
+// and within the time window.
+func queryIndex(db *sql.DB, apids Apids, win Window) ([]pathtm.Entry, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if len(apids) > 0 {
+		var vs []string
+		for _, a := range apids {
+			if a > 0 {
+				vs, args = append(vs, "?"), append(args, a)
+			}
+		}
+		if len(vs) > 0 {
+			where = append(where, "apid in ("+strings.Join(vs, ",")+")")
+		}
+	}
+	field := "esa_time"
+	if win.Recv {
+		field = "pth_time"
+	}
+	if !win.From.IsZero() {
+		where, args = append(where, field+">=?"), append(args, win.From.UnixNano())
+	}
+	if !win.To.IsZero() {
+		where, args = append(where, field+"<?"), append(args, win.To.UnixNano())
+	}
+	query := "select file, position, length, apid, sid, sequence, esa_time, pth_time, hash from packets"
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by file, position"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var es []pathtm.Entry
+	for rows.Next() {
+		var (
+			e        pathtm.Entry
+			esa, pth int64
+			sum      int64
+		)
+		err := rows.Scan(&e.File, &e.Offset, &e.Length, &e.Apid, &e.Sid, &e.Sequence, &esa, &pth, &sum)
+		if err != nil {
+			return nil, err
+		}
+		e.ESATime, e.PTHTime, e.Hash = time.Unix(0, esa).UTC(), time.Unix(0, pth).UTC(), uint64(sum)
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+// walkFiles gives the regular files found in the given files and directories
+// in lexical order.
+func walkFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		err := filepath.Walk(p, func(file string, i os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if i.Mode().IsRegular() {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// indexedList decodes the packets found in the index instead of browsing the
+// files of the archive.
+func indexedList(file string, apids Apids, win Window, filter pathtm.Filter, pr *Printer, size int) error {
+	db, err := openIndex(file)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	es, err := queryIndex(db, apids, win)
+	if err != nil {
+		return err
+	}
+	r := pathtm.NewIndexedReader(es, filter)
+	defer r.Close()
+
+	return dumpList(r, pr, size)
+}
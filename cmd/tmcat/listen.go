@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/busoc/rt"
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+func runListen(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	udp := cmd.Flag.String("u", "", "listen for packets on udp address")
+	client := cmd.Flag.String("t", "", "receive packets from tcp address")
+	server := cmd.Flag.String("s", "", "accept tcp connections on address")
+	interval := cmd.Flag.Duration("d", rt.Five, "interval")
+	raw := cmd.Flag.Bool("raw", false, "packets without PTH header")
+	list := cmd.Flag.Bool("l", false, "print packets in list format")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	ws, err := NewWriter(cmd.Flag.Arg(0), *interval)
+	if err != nil {
+		return err
+	}
+	ws.stamp = *raw
+	defer ws.Close()
+
+	a := archiver{
+		writer:  ws,
+		filter:  filter,
+		tracker: pathtm.NewGapTracker(),
+		line:    Line(false),
+		gaps:    os.Stdout,
+		stats:   make(map[key]stat),
+	}
+	if *list {
+		var out Output
+		if a.list, err = out.Printer(os.Stdout); err != nil {
+			return err
+		}
+		a.gaps = os.Stderr
+	}
+
+	var s session
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		s.Stop()
+	}()
+
+	switch {
+	case *udp != "":
+		err = listenUDP(&s, *udp, *raw, &a)
+	case *client != "":
+		err = dialTCP(&s, *client, *raw, &a)
+	case *server != "":
+		err = serveTCP(&s, *server, *raw, &a)
+	default:
+		return fmt.Errorf("no address given")
+	}
+	a.Report()
+	return err
+}
+
+// session keeps track of the connections opened by listen so that they can all
+// be closed when the command is interrupted.
+type session struct {
+	mu      sync.Mutex
+	stopped bool
+	conns   map[io.Closer]struct{}
+}
+
+// Track registers c. It reports false and closes c if the session has already
+// been stopped.
+func (s *session) Track(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		c.Close()
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[io.Closer]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *session) Release(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	c.Close()
+}
+
+func (s *session) Stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+func (s *session) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func listenUDP(s *session, addr string, raw bool, a *archiver) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	if !s.Track(conn) {
+		return nil
+	}
+	defer s.Release(conn)
+
+	buffer := make([]byte, 1<<16)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if s.Stopped() {
+				return nil
+			}
+			return err
+		}
+		var p pathtm.Packet
+		if raw {
+			p, err = pathtm.DecodeRawPacket(buffer[:n], true)
+		} else {
+			p, err = pathtm.DecodePacket(buffer[:n], true)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if err := a.Archive(p); err != nil {
+			return err
+		}
+	}
+}
+
+// dialTCP receives packets from addr. The connection is opened again, waiting
+// longer after each failed attempt, each time it is lost or the stream can not
+// be decoded anymore.
+func dialTCP(s *session, addr string, raw bool, a *archiver) error {
+	wait := minBackoff
+	for !s.Stopped() {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			var n int
+			n, err = receive(s, conn, raw, a)
+			if n > 0 {
+				wait = minBackoff
+			}
+		}
+		if s.Stopped() {
+			break
+		}
+		if _, ok := err.(archiveError); ok {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s (retry in %s)\n", addr, err, wait)
+		}
+		time.Sleep(wait)
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+	return nil
+}
+
+// serveTCP accepts connections on addr and receives the packets sent by each
+// of them until they are closed.
+func serveTCP(s *session, addr string, raw bool, a *archiver) error {
+	srv, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if !s.Track(srv) {
+		return nil
+	}
+	defer s.Release(srv)
+
+	var (
+		grp  sync.WaitGroup
+		errs = make(chan error, 1)
+	)
+	defer grp.Wait()
+	for {
+		conn, err := srv.Accept()
+		if err != nil {
+			if !s.Stopped() {
+				return err
+			}
+			select {
+			case err := <-errs:
+				return err
+			default:
+				return nil
+			}
+		}
+		grp.Add(1)
+		go func(c net.Conn) {
+			defer grp.Done()
+			_, err := receive(s, c, raw, a)
+			switch err.(type) {
+			case nil:
+			case archiveError:
+				select {
+				case errs <- err:
+				default:
+				}
+				s.Stop()
+			default:
+				fmt.Fprintf(os.Stderr, "%s: %s\n", c.RemoteAddr(), err)
+			}
+		}(conn)
+		select {
+		case err := <-errs:
+			return err
+		default:
+		}
+	}
+}
+
+// receive decodes the packets of a stream until it is closed or its framing is
+// lost. It gives the number of packets received. The packets with invalid
+// headers are reported and skipped.
+func receive(s *session, conn net.Conn, raw bool, a *archiver) (int, error) {
+	if !s.Track(conn) {
+		return 0, nil
+	}
+	defer s.Release(conn)
+
+	framing := pathtm.FramePTH
+	if raw {
+		framing = pathtm.FrameCCSDS
+	}
+	d := pathtm.NewFramedDecoder(conn, framing, nil)
+	for n := 0; ; n++ {
+		switch p, err := d.Decode(true); err {
+		case nil:
+			if err := a.Archive(p); err != nil {
+				return n, archiveError{err}
+			}
+		case io.EOF:
+			return n, nil
+		default:
+			if s.Stopped() {
+				return n, nil
+			}
+			// the headers of a packet are invalid but the stream can still be
+			// decoded: the packet is only reported.
+			if e, ok := err.(*pathtm.DecodeError); ok && (e.Stage == pathtm.StageCCSDS || e.Stage == pathtm.StageESA) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", conn.RemoteAddr(), err)
+				continue
+			}
+			return n, err
+		}
+	}
+}
+
+// archiveError reports that packets could not be written into the archive.
+// Unlike the errors of the connections, it stops listen.
+type archiveError struct {
+	error
+}
+
+// archiver writes the packets received by listen into the archive and reports
+// the discontinuities of their sequence counters as they come. The packets are
+// written to the files as soon as they are received and counted by apid. It can
+// be used by several connections at once.
+type archiver struct {
+	mu      sync.Mutex
+	writer  *writer
+	filter  pathtm.Filter
+	tracker *pathtm.GapTracker
+	line    *linewriter.Writer
+	gaps    io.Writer
+	list    *Printer
+	stats   map[key]stat
+}
+
+func (a *archiver) Archive(p pathtm.Packet) error {
+	if ok, err := a.filter(p.PTHHeader, p.CCSDSHeader, p.ESAHeader); !ok || err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := a.tracker.Track(p)
+	k := key{Pid: p.Apid()}
+	cz := a.stats[k]
+	cz.Update(p, c)
+	a.stats[k] = cz
+
+	if c.Kind != pathtm.SeqInOrder {
+		fd, td := c.Last.Timestamp(), p.Timestamp()
+
+		a.line.AppendTime(time.Now().UTC(), rt.TimeFormat, linewriter.AlignRight)
+		a.line.AppendUint(uint64(p.Apid()), 4, linewriter.AlignRight)
+		a.line.AppendTime(fd, rt.TimeFormat, linewriter.AlignRight)
+		a.line.AppendTime(td, rt.TimeFormat, linewriter.AlignRight)
+		a.line.AppendUint(uint64(c.Last.Sequence()), 6, linewriter.AlignRight)
+		a.line.AppendUint(uint64(p.Sequence()), 6, linewriter.AlignRight)
+		a.line.AppendUint(uint64(c.Missing), 6, linewriter.AlignRight)
+		a.line.AppendString(c.Kind.String(), 12, linewriter.AlignRight)
+
+		a.gaps.Write(append(a.line.Bytes(), '\n'))
+		a.line.Reset()
+	}
+	if a.list != nil {
+		if err := printPacket(a.list, p, c.Missing, 0); err != nil {
+			return err
+		}
+	}
+	if err := a.writer.WritePacket(p); err != nil {
+		return err
+	}
+	return a.writer.Flush()
+}
+
+// Report prints the counters of each apid received.
+func (a *archiver) Report() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, k := range keyset(a.stats) {
+		cz := a.stats[k]
+		a.line.AppendUint(uint64(k.Pid), 4, linewriter.AlignRight)
+		a.line.AppendUint(cz.Count, 8, linewriter.AlignRight)
+		a.line.AppendUint(cz.Missing, 8, linewriter.AlignRight)
+		a.line.AppendUint(cz.Duplicate, 8, linewriter.AlignRight)
+		a.line.AppendUint(cz.Disorder, 8, linewriter.AlignRight)
+		a.line.AppendUint(cz.Reset, 8, linewriter.AlignRight)
+		a.line.AppendUint(cz.First, 8, linewriter.AlignRight)
+		a.line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
+		a.line.AppendUint(cz.Last, 8, linewriter.AlignRight)
+		a.line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
+
+		a.gaps.Write(append(a.line.Bytes(), '\n'))
+		a.line.Reset()
+	}
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/busoc/pathtm"
 	"github.com/midbel/cli"
 	"github.com/midbel/linewriter"
 )
@@ -21,37 +28,81 @@ Available commands:
 Use {{.Name}} [command] -h for more information about its usage.
 `
 
+// maxApid is the highest apid accepted when skipping corrupted data. 0x7FF is
+// reserved for idle packets.
+const maxApid = 0x7FE
+
 var commands = []*cli.Command{
 	{
-		Usage: "list [-c csv] [-p apid] <file...>",
+		Usage: "list [-f expr] [-from time] [-to time] [-recv] [-o format] [-c csv] [-r resync] [-x index] [-p apid...] <file...>",
 		Short: "print packet headers found in file(s)",
 		Run:   runList,
 	},
 	{
-		Usage: "diff [-c csv] [-p apid] [-d duration] <file...>",
+		Usage: "diff [-f expr] [-from time] [-to time] [-recv] [-o format] [-c csv] [-r resync] [-j jobs] [-p apid...] [-d duration] <file...>",
 		Short: "print packet gap(s) found in file(s)",
 		Run:   runDiff,
 	},
 	{
-		Usage: "count [-p apid] [-i interval] [-c csv] [-b by] <file...>",
+		Usage: "count [-f expr] [-from time] [-to time] [-recv] [-r resync] [-j jobs] [-p apid...] [-i interval] [-o format] [-c csv] [-b by] <file...>",
 		Short: "count packets found into file(s)",
 		Run:   runCount,
 	},
 	{
-		Usage: "digest <file...>",
+		Usage: "check [-f expr] [-from time] [-to time] [-recv] [-o format] [-c csv] [-p apid...] -e apid... <file...>",
+		Short: "count packets with an invalid packet error control",
+		Run:   runCheck,
+	},
+	{
+		Usage: "digest [-f expr] [-from time] [-to time] [-recv] [-o format] [-j jobs] [-p apid...] <file...>",
 		Short: "print CCSDS headers and packet hash",
 		Run:   runDigest,
 	},
 	{
-		Usage: "take [-p apid] [-d duration] <pattern> <file...>",
+		Usage: "take [-f expr] [-from time] [-to time] [-recv] [-p apid...] [-d duration] [-z compression] <pattern> <file...>",
 		Short: "gather packets of an apid into its file(s)",
 		Run:   runTake,
 	},
 	{
-		Usage: "merge <final> <file...>",
+		Usage: "merge [-f expr] [-p apid...] [-z compression] <final> <file...>",
 		Short: "merge and reorder packets from multiple files",
 		Run:   runMerge,
 	},
+	{
+		Usage: "export [-f expr] [-from time] [-to time] [-recv] [-p apid...] [-o parquet] [-d data] <output> <file...>",
+		Short: "export packet headers into a parquet file",
+		Run:   runExport,
+	},
+	{
+		Usage: "listen [-f expr] [-from time] [-to time] [-recv] [-p apid...] [-d duration] [-raw] [-l] [-u|-t|-s <address>] <pattern>",
+		Short: "receive packets and write them into the archive",
+		Run:   runListen,
+	},
+	{
+		Usage: "replay [-f expr] [-p apid...] [-x speed] [-raw] [-loop] [-u|-t <address>] <archive...>",
+		Short: "send packets of an archive at the pace they have been received",
+		Run:   runReplay,
+	},
+	{
+		Usage: "gen [-n count] [-seed seed] [-t time] [-gap p] [-dup p] [-wrap p] [-version p] [-truncate p] [-jump p] [-reset p] -s <source...> [<file>]",
+		Short: "generate synthetic packets",
+		Run:   runGen,
+	},
+	{
+		Usage: "split [-f expr] [-from time] [-to time] [-recv] [-p apid...] [-by apid,sid,type,segment,day] [-d duration] [-s size] [-n files] <pattern> <file...>",
+		Short: "split packets into files by apid, sid, type or time",
+		Run:   runSplit,
+	},
+	{
+		Usage: "index [-update] <db> <file...>",
+		Short: "build an index of the packets found in file(s)",
+		Run:   runIndex,
+	},
+	{
+		Usage: "reassemble [-f expr] [-from time] [-to time] [-recv] [-p apid...] [-t timeout] <dir> <file...>",
+		Short: "join segmented packets and write their user data in dir",
+		Run:   runReassemble,
+	},
 }
 
 func main() {
@@ -75,3 +126,140 @@ func Line(csv bool) *linewriter.Writer {
 	}
 	return linewriter.NewWriter(1024, options...)
 }
+
+func printSkip(e pathtm.SkipError) {
+	fmt.Fprintln(os.Stderr, e)
+}
+
+// openArchive gives the pathtm.Opener of the files decoded by a
+// ParallelDecoder.
+func openArchive(file string) (io.ReadCloser, error) {
+	return openFile(file)
+}
+
+// Packets gives a decoder of the packets found in paths. With more than one
+// job, the files are decoded concurrently by as many workers.
+func Packets(paths []string, jobs int, filter pathtm.Filter, data, resync bool) (PacketDecoder, io.Closer, error) {
+	if jobs > 1 {
+		files, err := walkFiles(paths)
+		if err != nil {
+			return nil, nil, err
+		}
+		d := pathtm.NewParallelDecoder(files, jobs, openArchive, filter, data)
+		if resync {
+			d.Resync(0, maxApid, printSkip)
+		}
+		return d, d, nil
+	}
+	mr, err := Browse(paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := pathtm.NewDecoder(mr, filter)
+	if resync {
+		d.Resync(0, maxApid, printSkip)
+	}
+	return d, mr, nil
+}
+
+// skipPacket reports whether err is given for a packet that could not be
+// decoded. The error is then printed on stderr and the decoding goes on with
+// the next packet: after an error on the framing of a file, the decoders go on
+// with the next file or end the stream.
+func skipPacket(err error) bool {
+	var e *pathtm.DecodeError
+	if !errors.As(err, &e) {
+		return false
+	}
+	fmt.Fprintln(os.Stderr, e)
+	return true
+}
+
+// Filter combines the given filters with the one compiled from expr.
+func Filter(expr string, fs ...pathtm.Filter) (pathtm.Filter, error) {
+	if expr != "" {
+		f, err := pathtm.ParseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return pathtm.And(fs...), nil
+}
+
+// Apids collects the values of a flag that can be repeated to select several
+// apids. Each value can also be a comma separated list of apids.
+type Apids []int
+
+func (a *Apids) String() string {
+	vs := make([]string, len(*a))
+	for i, v := range *a {
+		vs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(vs, ",")
+}
+
+func (a *Apids) Set(str string) error {
+	for _, s := range strings.Split(str, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		*a = append(*a, v)
+	}
+	return nil
+}
+
+func (a *Apids) Filter() pathtm.Filter {
+	return pathtm.WithApids(*a...)
+}
+
+var timeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+type Time struct {
+	time.Time
+}
+
+func (t *Time) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (t *Time) Set(str string) error {
+	for _, f := range timeFormats {
+		w, err := time.Parse(f, str)
+		if err == nil {
+			t.Time = w
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid time: %s", str)
+}
+
+// Window restricts the packets to the ones generated (or received with -recv)
+// between the times given to -from and -to.
+type Window struct {
+	From Time
+	To   Time
+	Recv bool
+}
+
+func (w *Window) Register(fs *flag.FlagSet) {
+	fs.Var(&w.From, "from", "keep packets from this time")
+	fs.Var(&w.To, "to", "keep packets until this time")
+	fs.BoolVar(&w.Recv, "recv", false, "use PTH reception time instead of ESA time")
+}
+
+func (w *Window) Filter() pathtm.Filter {
+	if w.Recv {
+		return pathtm.ReceivedBetween(w.From.Time, w.To.Time)
+	}
+	return pathtm.Between(w.From.Time, w.To.Time)
+}
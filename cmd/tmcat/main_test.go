@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/midbel/cli"
+	"github.com/parquet-go/parquet-go"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// genArgs are the options given to gen to create the archive used by the
+// tests: three sources, one of them segmented, with gaps, duplicates and
+// counter wraps.
+var genArgs = []string{
+	"-n", "200",
+	"-seed", "42",
+	"-t", "2020-01-01T00:00:00Z",
+	"-s", "100:1:6:1s:16-64",
+	"-s", "200:2:3:250ms:32::16380",
+	"-s", "300:3:5:2s:100:3",
+	"-gap", "0.02",
+	"-dup", "0.02",
+	"-wrap", "0.02",
+}
+
+func TestCommands(t *testing.T) {
+	file := genArchive(t)
+
+	// diff and count are given the archive twice to compare the output of
+	// the parallel decoder with the one of the decoder.
+	data := []struct {
+		Name   string
+		Golden string
+		Args   []string
+	}{
+		{Name: "list", Golden: "list", Args: []string{"-o", "ndjson", file}},
+		{Name: "list", Golden: "list-apid", Args: []string{"-o", "ndjson", "-p", "200", file}},
+		{Name: "list", Golden: "list-filter", Args: []string{"-o", "ndjson", "-f", "sid = 1", file}},
+		{Name: "diff", Golden: "diff", Args: []string{"-o", "ndjson", file, file}},
+		{Name: "diff", Golden: "diff", Args: []string{"-o", "ndjson", "-j", "2", file, file}},
+		{Name: "count", Golden: "count", Args: []string{"-o", "ndjson", file, file}},
+		{Name: "count", Golden: "count", Args: []string{"-o", "ndjson", "-j", "2", file, file}},
+		{Name: "count", Golden: "count-sid", Args: []string{"-o", "ndjson", "-b", "sid", file}},
+		{Name: "digest", Golden: "digest", Args: []string{"-o", "ndjson", file}},
+		{Name: "digest", Golden: "digest", Args: []string{"-o", "ndjson", "-j", "2", file}},
+		{Name: "check", Golden: "check", Args: []string{"-o", "ndjson", "-e", "200", file}},
+	}
+	for _, d := range data {
+		got := runCommand(t, d.Name, d.Args)
+		checkGolden(t, d.Golden+".golden", got)
+	}
+}
+
+// TestOutputs checks the files written by the commands. The patterns given
+// to take and split do not depend on the packets so that the names of the
+// files are the same whatever the formatter of rt.
+func TestOutputs(t *testing.T) {
+	file := genArchive(t)
+
+	data := []struct {
+		Name   string
+		Golden string
+		Args   []string
+	}{
+		{Name: "take", Golden: "take", Args: []string{"-p", "100", "{dir}/100.dat", file}},
+		{Name: "split", Golden: "split", Args: []string{"-by", "type,segment", "-s", "4k", "-n", "1", "{dir}/{type}/{segment}.dat", file}},
+		{Name: "reassemble", Golden: "reassemble", Args: []string{"-p", "300", "{dir}", file}},
+	}
+	for _, d := range data {
+		dir := t.TempDir()
+		args := make([]string, len(d.Args))
+		for i, a := range d.Args {
+			args[i] = strings.ReplaceAll(a, "{dir}", dir)
+		}
+		runCommand(t, d.Name, args)
+		checkGolden(t, d.Golden+".golden", listFiles(t, dir))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var (
+		file  = genArchive(t)
+		dir   = t.TempDir()
+		args  = []string{filepath.Join(dir, "merged.dat")}
+		final = args[0]
+	)
+	// the packets of each apid are merged back from their own file
+	for _, pid := range []string{"100", "200", "300"} {
+		out := filepath.Join(dir, pid+".dat")
+		runCommand(t, "take", []string{"-p", pid, out, file})
+		args = append(args, out)
+	}
+	runCommand(t, "merge", args)
+	checkGolden(t, "merge.golden", runCommand(t, "list", []string{"-o", "ndjson", final}))
+}
+
+func TestExport(t *testing.T) {
+	var (
+		file = genArchive(t)
+		out  = filepath.Join(t.TempDir(), "packets.parquet")
+	)
+	runCommand(t, "export", []string{"-p", "100", out, file})
+
+	rows, err := parquet.ReadFile[packetRow](out)
+	if err != nil {
+		t.Fatalf("fail to read %s: %s", out, err)
+	}
+	var buf bytes.Buffer
+	for _, r := range rows {
+		if err := json.NewEncoder(&buf).Encode(r); err != nil {
+			t.Fatalf("fail to encode row: %s", err)
+		}
+	}
+	checkGolden(t, "export.golden", buf.Bytes())
+}
+
+// TestIndex checks that the packets listed from the index are the ones listed
+// from the archive.
+func TestIndex(t *testing.T) {
+	var (
+		file = genArchive(t)
+		db   = filepath.Join(t.TempDir(), "index.db")
+	)
+	runCommand(t, "index", []string{db, file})
+	got := runCommand(t, "list", []string{"-o", "ndjson", "-x", db, "-p", "200"})
+	checkGolden(t, "list-apid.golden", got)
+}
+
+func TestGen(t *testing.T) {
+	buf, err := os.ReadFile(genArchive(t))
+	if err != nil {
+		t.Fatalf("fail to read archive: %s", err)
+	}
+	checkGolden(t, "gen.golden", buf)
+}
+
+func TestReplay(t *testing.T) {
+	file := genArchive(t)
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("fail to read archive: %s", err)
+	}
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("fail to listen on tcp: %s", err)
+	}
+	defer srv.Close()
+
+	var (
+		got  bytes.Buffer
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		conn, err := srv.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		io.Copy(&got, conn)
+	}()
+	runCommand(t, "replay", []string{"-x", "0", "-t", srv.Addr().String(), file})
+	<-done
+
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("replayed packets mismatched: want %d bytes, got %d bytes", len(want), got.Len())
+	}
+}
+
+// genArchive writes the packets generated with genArgs into a temporary file.
+func genArchive(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "archive.dat")
+	runCommand(t, "gen", append(append([]string(nil), genArgs...), file))
+	return file
+}
+
+// listFiles gives the name, the size and the checksum of the files found under
+// dir.
+func listFiles(t *testing.T, dir string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := filepath.Walk(dir, func(file string, i os.FileInfo, err error) error {
+		if err != nil || i.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		fmt.Fprintf(&buf, "%s %d %08x\n", filepath.ToSlash(rel), len(data), crc32.ChecksumIEEE(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fail to list files of %s: %s", dir, err)
+	}
+	return buf.Bytes()
+}
+
+// runCommand runs the tmcat command name with args and gives what it writes to
+// stdout.
+func runCommand(t *testing.T, name string, args []string) []byte {
+	t.Helper()
+	var cmd *cli.Command
+	for _, c := range commands {
+		if strings.Fields(c.Usage)[0] == name {
+			cmd = &cli.Command{Usage: c.Usage, Short: c.Short, Run: c.Run}
+			break
+		}
+	}
+	if cmd == nil {
+		t.Fatalf("%s: command not found", name)
+	}
+	// the errors reported by the commands on the corrupted packets of the
+	// archive are not part of their output.
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("fail to open %s: %s", os.DevNull, err)
+	}
+	defer null.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("fail to create pipe: %s", err)
+	}
+	var (
+		out    bytes.Buffer
+		done   = make(chan struct{})
+		stdout = os.Stdout
+		stderr = os.Stderr
+	)
+	go func() {
+		defer close(done)
+		io.Copy(&out, r)
+	}()
+	os.Stdout, os.Stderr = w, null
+	err = cmd.Run(cmd, args)
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	<-done
+	r.Close()
+
+	if err != nil {
+		t.Fatalf("%s %s: unexpected error: %s", name, strings.Join(args, " "), err)
+	}
+	return out.Bytes()
+}
+
+// checkGolden compares got with the content of the golden file or replaces it
+// when the tests are run with -update.
+func checkGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", golden)
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("fail to create testdata: %s", err)
+		}
+		if err := os.WriteFile(file, got, 0644); err != nil {
+			t.Fatalf("fail to update %s: %s", file, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("fail to read %s: %s", file, err)
+	}
+	switch {
+	case bytes.Equal(got, want):
+	case utf8.Valid(want):
+		t.Errorf("%s: output mismatched (run the tests with -update to replace it)\nwant:\n%s\ngot:\n%s", golden, want, got)
+	default:
+		t.Errorf("%s: output mismatched (run the tests with -update to replace it)", golden)
+	}
+}
@@ -1,40 +1,64 @@
 package main
 
 import (
-	"bufio"
+	"fmt"
 	"io"
 	"os"
 
 	"github.com/busoc/pathtm"
-	"github.com/busoc/rt"
 	"github.com/midbel/cli"
 	"github.com/midbel/linewriter"
 	"github.com/midbel/xxh"
 )
 
+type digestRecord struct {
+	Apid         uint16 `json:"apid"`
+	Missing      int    `json:"missing"`
+	Sequence     uint16 `json:"sequence"`
+	Segmentation string `json:"segmentation"`
+	Length       uint16 `json:"length"`
+	Hash         string `json:"hash"`
+}
+
 func runDigest(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	var out Output
+	out.Register(&cmd.Flag)
+	jobs := cmd.Flag.Int("j", 1, "number of files decoded concurrently")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	d, c, err := Packets(cmd.Flag.Args(), *jobs, filter, true, false)
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
+	defer c.Close()
 
-	r := bufio.NewReader(rt.NewReader(mr))
-	buffer := make([]byte, pathtm.BufferSize)
-	line := Line(false)
+	pr, err := out.Printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
 
 	seen := make(map[uint16]pathtm.CCSDSHeader)
 	for {
-		switch _, err := r.Read(buffer); err {
+		switch p, err := d.Decode(true); err {
 		case nil:
-			c, err := pathtm.DecodeCCSDS(buffer[pathtm.PTHHeaderLen:])
-			if err != nil {
-				return err
+			var sum uint64
+			if buf, err := p.Marshal(); err == nil {
+				sum = xxh.Sum64(buf[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:], 0)
 			}
-			sum := xxh.Sum64(buffer[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:], 0)
+			c := p.CCSDSHeader
 
 			var missing int
 			if other, ok := seen[c.Apid()]; ok {
@@ -44,18 +68,31 @@ func runDigest(cmd *cli.Command, args []string) error {
 			}
 			seen[c.Apid()] = c
 
-			line.AppendUint(uint64(c.Apid()), 4, linewriter.AlignRight)
-			line.AppendUint(uint64(missing), 6, linewriter.AlignRight)
-			line.AppendUint(uint64(c.Sequence()), 6, linewriter.AlignRight)
-			line.AppendString(c.Segmentation().String(), 12, linewriter.AlignRight)
-			line.AppendUint(uint64(c.Len()), 6, linewriter.AlignRight)
-			line.AppendUint(sum, 16, linewriter.WithZero|linewriter.Hex)
-
-			os.Stdout.Write(append(line.Bytes(), '\n'))
-			line.Reset()
+			rec := digestRecord{
+				Apid:         c.Apid(),
+				Missing:      missing,
+				Sequence:     c.Sequence(),
+				Segmentation: c.Segmentation().String(),
+				Length:       c.Len(),
+				Hash:         fmt.Sprintf("%016x", sum),
+			}
+			err := pr.Print(rec, func(line *linewriter.Writer) {
+				line.AppendUint(uint64(c.Apid()), 4, linewriter.AlignRight)
+				line.AppendUint(uint64(missing), 6, linewriter.AlignRight)
+				line.AppendUint(uint64(c.Sequence()), 6, linewriter.AlignRight)
+				line.AppendString(c.Segmentation().String(), 12, linewriter.AlignRight)
+				line.AppendUint(uint64(c.Len()), 6, linewriter.AlignRight)
+				line.AppendUint(sum, 16, linewriter.WithZero|linewriter.Hex)
+			})
+			if err != nil {
+				return err
+			}
 		case io.EOF:
 			return nil
 		default:
+			if skipPacket(err) {
+				continue
+			}
 			return err
 		}
 	}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/busoc/pathtm"
+	"github.com/busoc/rt"
+	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
+)
+
+func runReassemble(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	timeout := cmd.Flag.Duration("t", 0, "maximum time between two segments")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	mr, err := Browse(cmd.Flag.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var (
+		dir  = cmd.Flag.Arg(0)
+		d    = pathtm.NewDecoder(mr, filter)
+		r    = pathtm.NewReassembler(*timeout)
+		line = Line(false)
+	)
+	for {
+		p, err := d.Decode(true)
+		switch err {
+		case nil:
+		case io.EOF:
+			for _, e := range r.Flush() {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			return nil
+		default:
+			if skipPacket(err) {
+				continue
+			}
+			return err
+		}
+		for _, e := range r.Expire(p.Timestamp()) {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		u, ok, err := r.Push(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if !ok {
+			continue
+		}
+		file, err := writeUnit(dir, u)
+		if err != nil {
+			return err
+		}
+		line.AppendUint(uint64(u.Apid()), 4, linewriter.AlignRight)
+		line.AppendTime(u.Timestamp(), rt.TimeFormat, 0)
+		line.AppendUint(uint64(u.Sequence()), 6, linewriter.AlignRight)
+		line.AppendUint(uint64(u.Last.Sequence()), 6, linewriter.AlignRight)
+		line.AppendUint(uint64(u.Count), 6, linewriter.AlignRight)
+		line.AppendUint(uint64(len(u.Data)), 8, linewriter.AlignRight)
+		line.AppendString(file, 64, linewriter.AlignLeft)
+
+		io.Copy(os.Stdout, line)
+	}
+}
+
+func writeUnit(dir string, u pathtm.Unit) (string, error) {
+	file := fmt.Sprintf("%s_%05d.dat", u.Timestamp().Format("20060102_150405"), u.Sequence())
+	file = filepath.Join(dir, fmt.Sprintf("%04d", u.Apid()), file)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, u.Data, 0644)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/midbel/cli"
+)
+
+func runReplay(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	udp := cmd.Flag.String("u", "", "send packets to udp address")
+	tcp := cmd.Flag.String("t", "", "send packets to tcp address")
+	speed := cmd.Flag.Float64("x", 1, "speed factor (0 to send as fast as possible)")
+	raw := cmd.Flag.Bool("raw", false, "send packets without PTH header")
+	loop := cmd.Flag.Bool("loop", false, "replay the packets again once finished")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	if *speed < 0 {
+		return fmt.Errorf("invalid speed factor: %f", *speed)
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	switch {
+	case *udp != "":
+		conn, err = net.Dial("udp", *udp)
+	case *tcp != "":
+		conn, err = net.Dial("tcp", *tcp)
+	default:
+		err = fmt.Errorf("no address given")
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := replayer{
+		inner: conn,
+		speed: *speed,
+		raw:   *raw,
+	}
+	for {
+		if err := r.Replay(cmd.Flag.Args(), filter); err != nil {
+			return err
+		}
+		if !*loop {
+			return nil
+		}
+	}
+}
+
+// replayer sends the packets of an archive, keeping the delays between their
+// PTH times divided by speed. With a speed of 0, packets are sent without
+// delay.
+type replayer struct {
+	inner io.Writer
+	speed float64
+	raw   bool
+}
+
+func (r replayer) Replay(dirs []string, filter pathtm.Filter) error {
+	mr, err := Browse(dirs)
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var (
+		d     = pathtm.NewDecoder(mr, filter)
+		first time.Time
+		start time.Time
+	)
+	for {
+		switch p, err := d.Decode(true); err {
+		case nil:
+			if r.speed > 0 {
+				when := p.PTHHeader.Timestamp()
+				if first.IsZero() {
+					first, start = when, time.Now()
+				}
+				elapsed := time.Duration(float64(when.Sub(first)) / r.speed)
+				if wait := time.Until(start.Add(elapsed)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			switch err := r.send(p); err {
+			case nil:
+			case pathtm.ErrEmpty:
+				fmt.Fprintf(os.Stderr, "apid %d: packet %d skipped: %s\n", p.Apid(), p.Sequence(), err)
+			default:
+				return err
+			}
+		case io.EOF:
+			return nil
+		default:
+			if skipPacket(err) {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+func (r replayer) send(p pathtm.Packet) error {
+	buf, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	if r.raw {
+		buf = buf[pathtm.PTHHeaderLen:]
+	}
+	_, err = r.inner.Write(buf)
+	return err
+}
@@ -14,35 +14,74 @@ import (
 )
 
 func runList(cmd *cli.Command, args []string) error {
-	apid := cmd.Flag.Int("p", 0, "apid")
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
 	hrdp := cmd.Flag.Bool("a", false, "hrdp")
-	csv := cmd.Flag.Bool("c", false, "csv format")
+	var out Output
+	out.Register(&cmd.Flag)
+	resync := cmd.Flag.Bool("r", false, "skip corrupted data")
+	index := cmd.Flag.String("x", "", "read packets from index")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pathtm.NewDecoder(rt.NewReader(mr), pathtm.WithApid(*apid))
+	pr, err := out.Printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
 
 	var base int
 	if *hrdp {
 		base = pathtm.PTHHeaderLen + pathtm.CCSDSHeaderLen
 	}
-	return dumpList(d, os.Stdout, base, *csv)
+	if *index != "" {
+		return indexedList(*index, apids, win, filter, pr, base)
+	}
+
+	mr, err := Browse(cmd.Flag.Args())
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+	d := pathtm.NewDecoder(mr, filter)
+	if *resync {
+		d.Resync(0, maxApid, printSkip)
+	}
+	return dumpList(d, pr, base)
 }
 
-func dumpList(d *pathtm.Decoder, w io.Writer, size int, csv bool) error {
-	line := Line(csv)
+// PacketDecoder is implemented by pathtm.Decoder, pathtm.ParallelDecoder and
+// pathtm.IndexedReader.
+type PacketDecoder interface {
+	Decode(bool) (pathtm.Packet, error)
+}
+
+type listRecord struct {
+	Apid         uint16    `json:"apid"`
+	Sid          uint32    `json:"sid"`
+	Sequence     uint16    `json:"sequence"`
+	Segmentation string    `json:"segmentation"`
+	Type         string    `json:"type"`
+	ESATime      time.Time `json:"esa_time"`
+	PTHTime      time.Time `json:"pth_time"`
+	Missing      int       `json:"missing"`
+	Length       int       `json:"length"`
+}
+
+func dumpList(d PacketDecoder, pr *Printer, size int) error {
 	seen := make(map[uint16]pathtm.Packet)
 	for {
 		switch p, err := d.Decode(false); err {
 		case nil:
-			ft := p.CCSDSHeader.Segmentation()
-			pt := p.ESAHeader.PacketType()
-
 			var diff int
 			if other, ok := seen[p.Apid()]; ok {
 				diff = p.Missing(other)
@@ -51,34 +90,42 @@ func dumpList(d *pathtm.Decoder, w io.Writer, size int, csv bool) error {
 				}
 			}
 			seen[p.Apid()] = p
-
-			line.AppendTime(p.Timestamp(), rt.TimeFormat, 0)
-			line.AppendTime(p.PTHHeader.Timestamp(), rt.TimeFormat, 0)
-			line.AppendUint(uint64(p.Sequence()), 6, linewriter.AlignRight)
-			line.AppendUint(uint64(diff), 6, linewriter.AlignRight)
-			line.AppendString(ft.String(), 16, linewriter.AlignRight)
-			line.AppendUint(uint64(p.Apid()), 4, linewriter.AlignRight)
-			line.AppendUint(uint64(p.Len()+uint16(size)), 6, linewriter.AlignRight)
-			line.AppendString(pt.String(), 16, linewriter.AlignRight)
-			line.AppendUint(uint64(p.Sid), 8, linewriter.AlignRight)
-
-			io.Copy(w, line)
-		case io.EOF, rt.ErrInvalid:
-			return nil
+			if err := printPacket(pr, p, diff, size); err != nil {
+				return err
+			}
 		default:
+			if err == io.EOF {
+				return nil
+			}
+			if skipPacket(err) {
+				continue
+			}
 			return err
 		}
 	}
 }
 
 func runCount(cmd *cli.Command, args []string) error {
-	apid := cmd.Flag.Int("p", 0, "count packets only by apid")
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "count packets only by apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
 	interval := cmd.Flag.Duration("i", 0, "count packets within interval")
-	csv := cmd.Flag.Bool("c", false, "csv")
+	var out Output
+	out.Register(&cmd.Flag)
 	by := cmd.Flag.String("b", "", "count packets by")
+	resync := cmd.Flag.Bool("r", false, "skip corrupted data")
+	jobs := cmd.Flag.Int("j", 1, "number of files decoded concurrently")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
 	var groupby KeyFunc
 	switch *by {
 	case "", "apid":
@@ -88,87 +135,177 @@ func runCount(cmd *cli.Command, args []string) error {
 	default:
 		return fmt.Errorf("invalid value: %s", *by)
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	d, c, err := Packets(cmd.Flag.Args(), *jobs, filter, false, *resync)
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pathtm.NewDecoder(rt.NewReader(mr), pathtm.WithApid(*apid))
+	defer c.Close()
+
+	pr, err := out.Printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
 
-	line := Line(*csv)
 	stats, err := countPackets(d, groupby)
 	if err != nil {
 		return err
 	}
+	withGaps := *by == "" || *by == "apid"
 	for i, ks := 0, keyset(stats); i < len(ks); i++ {
-		k := ks[i]
-		line.AppendUint(uint64(k.Pid), 6, linewriter.AlignLeft)
-		if k.Sid > 0 {
-			line.AppendUint(uint64(k.Sid), 6, linewriter.AlignLeft)
+		k, cz := ks[i], stats[ks[i]]
+		rec := countRecord{
+			Apid:          k.Pid,
+			Sid:           k.Sid,
+			Count:         cz.Count,
+			Size:          cz.Size,
+			FirstSequence: cz.First,
+			FirstTime:     cz.StartTime,
+			LastSequence:  cz.Last,
+			LastTime:      cz.EndTime,
 		}
-
-		cz := stats[k]
-		line.AppendUint(cz.Count, 8, linewriter.AlignRight)
-		if *by == "" || *by == "apid" {
-			line.AppendUint(cz.Missing, 8, linewriter.AlignRight)
+		if !k.When.IsZero() {
+			rec.Time = &k.When
 		}
-		if *csv {
-			line.AppendUint(cz.Size, 8, linewriter.AlignRight)
-		} else {
-			line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+		if withGaps {
+			rec.Missing, rec.Duplicate = &cz.Missing, &cz.Duplicate
+			rec.Disorder, rec.Reset = &cz.Disorder, &cz.Reset
+		}
+		err := pr.Print(rec, func(line *linewriter.Writer) {
+			line.AppendUint(uint64(k.Pid), 6, linewriter.AlignLeft)
+			if k.Sid > 0 {
+				line.AppendUint(uint64(k.Sid), 6, linewriter.AlignLeft)
+			}
+			line.AppendUint(cz.Count, 8, linewriter.AlignRight)
+			if withGaps {
+				line.AppendUint(cz.Missing, 8, linewriter.AlignRight)
+				line.AppendUint(cz.Duplicate, 8, linewriter.AlignRight)
+				line.AppendUint(cz.Disorder, 8, linewriter.AlignRight)
+				line.AppendUint(cz.Reset, 8, linewriter.AlignRight)
+			}
+			if pr.CSV() {
+				line.AppendUint(cz.Size, 8, linewriter.AlignRight)
+			} else {
+				line.AppendSize(int64(cz.Size), 8, linewriter.AlignRight)
+			}
+			line.AppendUint(cz.First, 8, linewriter.AlignRight)
+			line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
+			line.AppendUint(cz.Last, 8, linewriter.AlignRight)
+			line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
+		})
+		if err != nil {
+			return err
 		}
-		line.AppendUint(cz.First, 8, linewriter.AlignRight)
-		line.AppendTime(cz.StartTime, rt.TimeFormat, linewriter.AlignRight)
-		line.AppendUint(cz.Last, 8, linewriter.AlignRight)
-		line.AppendTime(cz.EndTime, rt.TimeFormat, linewriter.AlignRight)
-
-		io.Copy(os.Stdout, line)
 	}
 	return nil
 }
 
+type countRecord struct {
+	Apid          uint16     `json:"apid"`
+	Sid           uint32     `json:"sid,omitempty"`
+	Time          *time.Time `json:"time,omitempty"`
+	Count         uint64     `json:"count"`
+	Missing       *uint64    `json:"missing,omitempty"`
+	Duplicate     *uint64    `json:"duplicate,omitempty"`
+	Disorder      *uint64    `json:"out_of_order,omitempty"`
+	Reset         *uint64    `json:"reset,omitempty"`
+	Size          uint64     `json:"size"`
+	FirstSequence uint64     `json:"first_sequence"`
+	FirstTime     time.Time  `json:"first_time"`
+	LastSequence  uint64     `json:"last_sequence"`
+	LastTime      time.Time  `json:"last_time"`
+}
+
 func runDiff(cmd *cli.Command, args []string) error {
-	apid := cmd.Flag.Int("p", 0, "apid")
-	csv := cmd.Flag.Bool("c", false, "csv")
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	var out Output
+	out.Register(&cmd.Flag)
 	duration := cmd.Flag.Duration("d", 0, "minimum gap duration")
+	resync := cmd.Flag.Bool("r", false, "skip corrupted data")
+	jobs := cmd.Flag.Int("j", 1, "number of files decoded concurrently")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	mr, err := rt.Browse(cmd.Flag.Args(), true)
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
 	if err != nil {
 		return err
 	}
-	defer mr.Close()
-	d := pathtm.NewDecoder(rt.NewReader(mr), pathtm.WithApid(*apid))
+	d, c, err := Packets(cmd.Flag.Args(), *jobs, filter, false, *resync)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
 
-	line := Line(*csv)
-	stats := make(map[uint16]pathtm.Packet)
+	pr, err := out.Printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	tracker := pathtm.NewGapTracker()
 	for {
 		switch p, err := d.Decode(false); err {
 		case nil:
-			if other, ok := stats[p.Apid()]; ok {
-				fd, td := other.Timestamp(), p.Timestamp()
-				if diff := p.Missing(other); diff > 0 && (*duration <= 0 || td.Sub(fd) >= *duration) {
-					line.AppendUint(uint64(p.Apid()), 4, linewriter.AlignRight)
-					line.AppendTime(fd, rt.TimeFormat, linewriter.AlignRight)
-					line.AppendTime(td, rt.TimeFormat, linewriter.AlignRight)
-					line.AppendUint(uint64(other.Sequence()), 6, linewriter.AlignRight)
-					line.AppendUint(uint64(p.Sequence()), 6, linewriter.AlignRight)
-					line.AppendUint(uint64(diff), 6, linewriter.AlignRight)
-					line.AppendDuration(td.Sub(fd), 12, linewriter.AlignRight)
-
-					io.Copy(os.Stdout, line)
-				}
+			c := tracker.Track(p)
+			if c.Kind == pathtm.SeqInOrder {
+				break
+			}
+			fd, td := c.Last.Timestamp(), p.Timestamp()
+			if c.Kind == pathtm.SeqGap && *duration > 0 && td.Sub(fd) < *duration {
+				break
+			}
+			rec := diffRecord{
+				Apid:         p.Apid(),
+				Kind:         c.Kind.String(),
+				FromTime:     fd,
+				ToTime:       td,
+				FromSequence: c.Last.Sequence(),
+				ToSequence:   p.Sequence(),
+				Missing:      c.Missing,
+				Duration:     td.Sub(fd).Seconds(),
+			}
+			err := pr.Print(rec, func(line *linewriter.Writer) {
+				line.AppendUint(uint64(p.Apid()), 4, linewriter.AlignRight)
+				line.AppendTime(fd, rt.TimeFormat, linewriter.AlignRight)
+				line.AppendTime(td, rt.TimeFormat, linewriter.AlignRight)
+				line.AppendUint(uint64(c.Last.Sequence()), 6, linewriter.AlignRight)
+				line.AppendUint(uint64(p.Sequence()), 6, linewriter.AlignRight)
+				line.AppendUint(uint64(c.Missing), 6, linewriter.AlignRight)
+				line.AppendDuration(td.Sub(fd), 12, linewriter.AlignRight)
+				line.AppendString(c.Kind.String(), 12, linewriter.AlignRight)
+			})
+			if err != nil {
+				return err
 			}
-			stats[p.Apid()] = p
-		case io.EOF, rt.ErrInvalid:
-			return nil
 		default:
+			if err == io.EOF {
+				return nil
+			}
+			if skipPacket(err) {
+				continue
+			}
 			return err
 		}
 	}
 }
 
+type diffRecord struct {
+	Apid         uint16    `json:"apid"`
+	Kind         string    `json:"kind"`
+	FromTime     time.Time `json:"from_time"`
+	ToTime       time.Time `json:"to_time"`
+	FromSequence uint16    `json:"from_sequence"`
+	ToSequence   uint16    `json:"to_sequence"`
+	Missing      int       `json:"missing"`
+	Duration     float64   `json:"duration"`
+}
+
 type key struct {
 	Pid  uint16
 	Sid  uint32
@@ -200,38 +337,60 @@ func bySource(d time.Duration) KeyFunc {
 	return f
 }
 
-func countPackets(d *pathtm.Decoder, groupby KeyFunc) (map[key]rt.Coze, error) {
-	stats := make(map[key]rt.Coze)
-	seen := make(map[uint16]pathtm.Packet)
+// stat extends the counters of rt.Coze with the number of packets of each
+// class given by pathtm.GapTracker.
+type stat struct {
+	rt.Coze
+	Duplicate uint64
+	Disorder  uint64
+	Reset     uint64
+}
+
+// Update counts p, c telling how p follows the previous packet of its apid.
+func (s *stat) Update(p pathtm.Packet, c pathtm.Continuity) {
+	s.Count++
+	s.Size += uint64(p.CCSDSHeader.Length)
+
+	s.Last, s.EndTime = uint64(p.Sequence()), p.Timestamp()
+	if s.StartTime.IsZero() {
+		s.First, s.StartTime = s.Last, s.EndTime
+	}
+	switch c.Kind {
+	case pathtm.SeqGap:
+		s.Missing += uint64(c.Missing)
+	case pathtm.SeqDuplicate:
+		s.Duplicate++
+	case pathtm.SeqOutOfOrder:
+		s.Disorder++
+	case pathtm.SeqReset:
+		s.Reset++
+	}
+}
+
+func countPackets(d PacketDecoder, groupby KeyFunc) (map[key]stat, error) {
+	stats := make(map[key]stat)
+	tracker := pathtm.NewGapTracker()
 
 	for {
 		switch p, err := d.Decode(false); err {
 		case nil:
 			k := groupby(p)
 			cz := stats[k]
-			cz.Count++
-			cz.Size += uint64(p.CCSDSHeader.Length)
-
-			cz.Last, cz.EndTime = uint64(p.Sequence()), p.Timestamp()
-			if cz.StartTime.IsZero() {
-				cz.First, cz.StartTime = cz.Last, cz.EndTime
+			cz.Update(p, tracker.Track(p))
+			stats[k] = cz
+		default:
+			if err == io.EOF {
+				return stats, nil
 			}
-
-			if other, ok := seen[p.Apid()]; ok {
-				if diff := p.Missing(other); diff > 0 {
-					cz.Missing += uint64(diff)
-				}
+			if skipPacket(err) {
+				continue
 			}
-			seen[p.Apid()], stats[k] = p, cz
-		case io.EOF, rt.ErrInvalid:
-			return stats, nil
-		default:
 			return nil, err
 		}
 	}
 }
 
-func keyset(stats map[key]rt.Coze) []key {
+func keyset(stats map[key]stat) []key {
 	var ks []key
 	for k := range stats {
 		ks = append(ks, k)
@@ -244,3 +403,126 @@ func keyset(stats map[key]rt.Coze) []key {
 	})
 	return ks
 }
+
+type checkStat struct {
+	Apid    uint16 `json:"apid"`
+	Count   uint64 `json:"count"`
+	Bad     uint64 `json:"bad"`
+	Missing uint64 `json:"missing"`
+}
+
+func runCheck(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		pec   Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	cmd.Flag.Var(&pec, "e", "apid of packets with packet error control")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	var out Output
+	out.Register(&cmd.Flag)
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	// without apids, the decoder would check the packets of all the apids
+	// and report all the packets without packet error control as bad.
+	if len(pec) == 0 {
+		return fmt.Errorf("no apid given with -e")
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	pr, err := out.Printer(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	mr, err := Browse(cmd.Flag.Args())
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+	d := pathtm.NewDecoder(mr, filter)
+	d.Checksum(pec...)
+
+	stats := make(map[uint16]*checkStat)
+	tracker := pathtm.NewGapTracker()
+	for {
+		p, err := d.Decode(false)
+		if err == io.EOF {
+			break
+		}
+		if skipPacket(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		cs, ok := stats[p.Apid()]
+		if !ok {
+			cs = &checkStat{Apid: p.Apid()}
+			stats[p.Apid()] = cs
+		}
+		cs.Count++
+		if !p.Valid() {
+			cs.Bad++
+		}
+		// packets with a bad checksum have been received: they are tracked
+		// too so that they are not counted as missing.
+		if c := tracker.Track(p); c.Kind == pathtm.SeqGap {
+			cs.Missing += uint64(c.Missing)
+		}
+	}
+
+	pids := make([]uint16, 0, len(stats))
+	for pid := range stats {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	for _, pid := range pids {
+		cs := stats[pid]
+		err := pr.Print(cs, func(line *linewriter.Writer) {
+			line.AppendUint(uint64(cs.Apid), 4, linewriter.AlignRight)
+			line.AppendUint(cs.Count, 8, linewriter.AlignRight)
+			line.AppendUint(cs.Bad, 8, linewriter.AlignRight)
+			line.AppendUint(cs.Missing, 8, linewriter.AlignRight)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printPacket(pr *Printer, p pathtm.Packet, diff, size int) error {
+	ft := p.CCSDSHeader.Segmentation()
+	pt := p.ESAHeader.PacketType()
+
+	rec := listRecord{
+		Apid:         p.Apid(),
+		Sid:          p.Sid,
+		Sequence:     p.Sequence(),
+		Segmentation: ft.String(),
+		Type:         pt.String(),
+		ESATime:      p.Timestamp(),
+		PTHTime:      p.PTHHeader.Timestamp(),
+		Missing:      diff,
+		Length:       int(p.Len()) + size,
+	}
+	return pr.Print(rec, func(line *linewriter.Writer) {
+		line.AppendTime(rec.ESATime, rt.TimeFormat, 0)
+		line.AppendTime(rec.PTHTime, rt.TimeFormat, 0)
+		line.AppendUint(uint64(rec.Sequence), 6, linewriter.AlignRight)
+		line.AppendUint(uint64(rec.Missing), 6, linewriter.AlignRight)
+		line.AppendString(rec.Segmentation, 16, linewriter.AlignRight)
+		line.AppendUint(uint64(rec.Apid), 4, linewriter.AlignRight)
+		line.AppendUint(uint64(rec.Length), 6, linewriter.AlignRight)
+		line.AppendString(rec.Type, 16, linewriter.AlignRight)
+		line.AppendUint(uint64(rec.Sid), 8, linewriter.AlignRight)
+	})
+}
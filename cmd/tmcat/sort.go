@@ -9,33 +9,76 @@ import (
 	"github.com/busoc/pathtm"
 	"github.com/busoc/rt"
 	"github.com/midbel/cli"
+	"github.com/midbel/linewriter"
 )
 
 func runMerge(cmd *cli.Command, args []string) error {
+	var apids Apids
+	cmd.Flag.Var(&apids, "p", "apid")
+	expr := cmd.Flag.String("f", "", "filter expression")
+	method := cmd.Flag.String("z", "", "compression of the file (gzip, zstd)")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
-	files := cmd.Flag.Args()
+	filter, err := Filter(*expr, apids.Filter())
+	if err != nil {
+		return err
+	}
+	files, clean, err := decompressFiles(cmd.Flag.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer clean()
+
 	w, err := os.Create(cmd.Flag.Arg(0))
 	if err != nil {
 		return err
 	}
 	defer w.Close()
 
-	return rt.MergeFiles(files[1:], w, func(bs []byte) (rt.Offset, error) {
+	c, err := compress(*method, w)
+	if err != nil {
+		return err
+	}
+	if err := mergeFiles(files, c, filter); err != nil {
+		return err
+	}
+	if err := c.Close(); err != nil {
+		return err
+	}
+	if *method != "" {
+		printCompression(Line(false), w.Name(), c)
+	}
+	return w.Close()
+}
+
+func mergeFiles(files []string, w io.Writer, filter pathtm.Filter) error {
+	return rt.MergeFiles(files, w, func(bs []byte) (rt.Offset, error) {
 		var o rt.Offset
 		if len(bs) < pathtm.PTHHeaderLen+pathtm.ESAHeaderLen {
 			return o, rt.ErrSkip
 		}
-		if c, err := pathtm.DecodeCCSDS(bs[pathtm.PTHHeaderLen:]); err != nil {
+		h, err := pathtm.DecodePTH(bs)
+		if err != nil {
+			return o, err
+		}
+		c, err := pathtm.DecodeCCSDS(bs[pathtm.PTHHeaderLen:])
+		if err != nil {
 			return o, err
-		} else {
-			o.Pid, o.Sequence = uint(c.Apid()), uint(c.Sequence())
 		}
-		if e, err := pathtm.DecodeESA(bs[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:]); err != nil {
+		o.Pid, o.Sequence = uint(c.Apid()), uint(c.Sequence())
+
+		e, err := pathtm.DecodeESA(bs[pathtm.PTHHeaderLen+pathtm.CCSDSHeaderLen:])
+		if err != nil {
+			return o, err
+		}
+		o.Time = e.Timestamp()
+
+		if ok, err := filter(h, c, e); !ok || err != nil {
+			if err == nil {
+				err = rt.ErrSkip
+			}
 			return o, err
-		} else {
-			o.Time = e.Timestamp()
 		}
 		return o, nil
 	})
@@ -44,8 +87,21 @@ func runMerge(cmd *cli.Command, args []string) error {
 type writer struct {
 	format   rt.Formatter
 	interval time.Duration
-	writers  map[uint16]*os.File
+	files    map[uint16]*outFile
 	times    map[uint16]time.Time
+
+	// stamp the PTH time of the packets with the time they are written
+	stamp bool
+	// compression of the files and line used to report their sizes when closed
+	method string
+	line   *linewriter.Writer
+}
+
+// outFile is a file of the archive being written.
+type outFile struct {
+	file *os.File
+	comp *compressor
+	enc  *pathtm.Encoder
 }
 
 func NewWriter(str string, interval time.Duration) (*writer, error) {
@@ -56,16 +112,26 @@ func NewWriter(str string, interval time.Duration) (*writer, error) {
 	w := writer{
 		format:   f,
 		interval: interval,
-		writers:  make(map[uint16]*os.File),
+		files:    make(map[uint16]*outFile),
 		times:    make(map[uint16]time.Time),
+		line:     Line(false),
 	}
 	return &w, nil
 }
 
+// Compress makes w compress the files it creates with the given method.
+func (w *writer) Compress(method string) error {
+	if _, err := compress(method, io.Discard); err != nil {
+		return err
+	}
+	w.method = method
+	return nil
+}
+
 func (w *writer) Close() error {
 	var err error
-	for _, c := range w.writers {
-		e := c.Close()
+	for apid := range w.files {
+		e := w.closeFile(apid)
 		if err == nil && e != nil {
 			err = e
 		}
@@ -73,6 +139,31 @@ func (w *writer) Close() error {
 	return err
 }
 
+// Flush writes the packets still buffered for the open files.
+func (w *writer) Flush() error {
+	for _, f := range w.files {
+		if err := f.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writer) closeFile(apid uint16) error {
+	f := w.files[apid]
+	err := f.enc.Flush()
+	if e := f.comp.Close(); err == nil {
+		err = e
+	}
+	if e := f.file.Close(); err == nil {
+		err = e
+	}
+	if err == nil && w.method != "" {
+		printCompression(w.line, f.file.Name(), f.comp)
+	}
+	return err
+}
+
 func (w *writer) WritePacket(p pathtm.Packet) error {
 	apid := p.Apid()
 	when := p.Timestamp().Truncate(w.interval)
@@ -86,7 +177,7 @@ func (w *writer) WritePacket(p pathtm.Packet) error {
 			Sid:  int(p.ESAHeader.Sid),
 			When: when,
 		}
-		file := w.format.Format(pi)
+		file := w.format.Format(pi) + extension(w.method)
 		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
 			return err
 		}
@@ -94,37 +185,64 @@ func (w *writer) WritePacket(p pathtm.Packet) error {
 		if err != nil {
 			return err
 		}
-		w.writers[apid] = wc
+		c, err := compress(w.method, wc)
+		if err != nil {
+			wc.Close()
+			return err
+		}
+		w.files[apid] = &outFile{
+			file: wc,
+			comp: c,
+			enc:  pathtm.NewEncoder(c, w.stamp),
+		}
 	}
 	if delta := when.Sub(stamp); delta >= w.interval {
-		if err := w.writers[apid].Close(); err != nil {
+		if err := w.closeFile(apid); err != nil {
 			return err
 		}
 		delete(w.times, apid)
-		delete(w.writers, apid)
+		delete(w.files, apid)
 		return w.WritePacket(p)
 	}
-	buf, err := p.Marshal()
-	if err == nil {
-		_, err = w.writers[apid].Write(buf)
+	return w.files[apid].enc.Encode(p)
+}
+
+// printCompression reports the size of a file before and after compression.
+func printCompression(line *linewriter.Writer, file string, c *compressor) {
+	var ratio float64
+	if c.raw.count > 0 {
+		ratio = float64(c.packed.count) / float64(c.raw.count)
 	}
-	return err
+	line.AppendString(file, 0, linewriter.AlignLeft)
+	line.AppendSize(c.raw.count, 8, linewriter.AlignRight)
+	line.AppendSize(c.packed.count, 8, linewriter.AlignRight)
+	line.AppendFloat(ratio*100, 6, 2, linewriter.AlignRight)
+	io.Copy(os.Stdout, line)
 }
 
 func runTake(cmd *cli.Command, args []string) error {
 	var (
-		apid     = cmd.Flag.Int("p", 0, "apid")
-		interval = cmd.Flag.Duration("d", rt.Five, "interval")
+		apids Apids
+		win   Window
 	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	interval := cmd.Flag.Duration("d", rt.Five, "interval")
+	method := cmd.Flag.String("z", "", "compression of the files (gzip, zstd)")
 	if err := cmd.Flag.Parse(args); err != nil {
 		return err
 	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
 
 	dirs := make([]string, cmd.Flag.NArg()-1)
 	for i := 1; i < cmd.Flag.NArg(); i++ {
 		dirs[i-1] = cmd.Flag.Arg(i)
 	}
-	mr, err := rt.Browse(dirs, true)
+	mr, err := Browse(dirs)
 	if err != nil {
 		return err
 	}
@@ -133,13 +251,19 @@ func runTake(cmd *cli.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := ws.Compress(*method); err != nil {
+		return err
+	}
 	defer func() {
 		mr.Close()
 		ws.Close()
 	}()
-	d := pathtm.NewDecoder(rt.NewReader(mr), pathtm.WithApid(*apid))
+	var (
+		d = pathtm.NewDecoder(mr, filter)
+		p pathtm.Packet
+	)
 	for {
-		switch p, err := d.Decode(true); err {
+		switch err := d.DecodeInto(&p); err {
 		case nil:
 			if err := ws.WritePacket(p); err != nil {
 				return err
@@ -147,6 +271,9 @@ func runTake(cmd *cli.Command, args []string) error {
 		case io.EOF:
 			return nil
 		default:
+			if skipPacket(err) {
+				continue
+			}
 			return err
 		}
 	}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busoc/pathtm"
+	"github.com/busoc/rt"
+	"github.com/midbel/cli"
+)
+
+const (
+	placeholderType    = "{type}"
+	placeholderSegment = "{segment}"
+	placeholderPart    = "{part}"
+)
+
+func runSplit(cmd *cli.Command, args []string) error {
+	var (
+		apids Apids
+		win   Window
+	)
+	cmd.Flag.Var(&apids, "p", "apid")
+	win.Register(&cmd.Flag)
+	expr := cmd.Flag.String("f", "", "filter expression")
+	by := cmd.Flag.String("by", "apid", "split by apid, sid, type, segment and/or day")
+	interval := cmd.Flag.Duration("d", 0, "interval")
+	size := cmd.Flag.String("s", "", "maximum size of the files")
+	open := cmd.Flag.Int("n", 64, "maximum number of files kept open")
+	if err := cmd.Flag.Parse(args); err != nil {
+		return err
+	}
+	filter, err := Filter(*expr, apids.Filter(), win.Filter())
+	if err != nil {
+		return err
+	}
+	maxSize, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+	s, err := NewSplitter(cmd.Flag.Arg(0), *by, *interval, maxSize, *open)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	mr, err := Browse(cmd.Flag.Args()[1:])
+	if err != nil {
+		return err
+	}
+	defer mr.Close()
+
+	var (
+		d = pathtm.NewDecoder(mr, filter)
+		p pathtm.Packet
+	)
+	for {
+		switch err := d.DecodeInto(&p); err {
+		case nil:
+			if err := s.WritePacket(p); err != nil {
+				return err
+			}
+		case io.EOF:
+			return s.Close()
+		default:
+			if skipPacket(err) {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// parseSize parses a number of bytes optionally followed by k, m or g.
+func parseSize(str string) (int64, error) {
+	if str == "" {
+		return 0, nil
+	}
+	var unit int64 = 1
+	switch str[len(str)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		str = str[:len(str)-1]
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", str)
+	}
+	return n * unit, nil
+}
+
+type splitKey struct {
+	Apid    uint16
+	Sid     uint32
+	Type    pathtm.ESAPacketType
+	Segment pathtm.CCSDSSegment
+	Day     time.Time
+}
+
+// slot tells in which file the packets of a group are written.
+type slot struct {
+	when time.Time
+	seq  int
+	name string
+}
+
+// part is a file being written. It is shared by all the groups of packets whose
+// file has the same name.
+type part struct {
+	name string
+	size int64
+
+	file *os.File
+	enc  *pathtm.Encoder
+	elem *list.Element
+}
+
+// splitter writes packets in files named after the pattern of an rt.Formatter
+// where {type}, {segment} and {part} are replaced by the packet type, the
+// segmentation flags of the first packet of the file and the ordinal of the
+// file when it has been rotated because of its size. Groups of packets whose
+// file names are the same, because the pattern does not depend on some of the
+// values used to split the packets, are written in the same file.
+//
+// Files are rotated every interval and when they would exceed maxSize bytes.
+// Only maxOpen files are kept open: the least recently written one is closed
+// and opened again in append mode if needed.
+type splitter struct {
+	format   rt.Formatter
+	pattern  string
+	by       map[string]bool
+	interval time.Duration
+	maxSize  int64
+	maxOpen  int
+
+	slots map[splitKey]*slot
+	parts map[string]*part
+	lru   *list.List
+}
+
+func NewSplitter(pattern, by string, interval time.Duration, maxSize int64, maxOpen int) (*splitter, error) {
+	f, err := rt.Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s := splitter{
+		format:   f,
+		pattern:  pattern,
+		by:       make(map[string]bool),
+		interval: interval,
+		maxSize:  maxSize,
+		maxOpen:  maxOpen,
+		slots:    make(map[splitKey]*slot),
+		parts:    make(map[string]*part),
+		lru:      list.New(),
+	}
+	if s.maxOpen <= 0 {
+		s.maxOpen = 1
+	}
+	for _, b := range strings.Split(by, ",") {
+		switch b = strings.TrimSpace(b); b {
+		case "apid", "sid", "type", "segment", "day":
+			s.by[b] = true
+		default:
+			return nil, fmt.Errorf("invalid value: %s", b)
+		}
+	}
+	return &s, nil
+}
+
+func (s *splitter) key(p pathtm.Packet) splitKey {
+	var k splitKey
+	if s.by["apid"] {
+		k.Apid = p.Apid()
+	}
+	if s.by["sid"] {
+		k.Sid = p.Sid
+	}
+	if s.by["type"] {
+		k.Type = p.PacketType()
+	}
+	if s.by["segment"] {
+		k.Segment = p.Segmentation()
+	}
+	if s.by["day"] {
+		k.Day = p.Timestamp().Truncate(time.Hour * 24)
+	}
+	return k
+}
+
+func (s *splitter) WritePacket(p pathtm.Packet) error {
+	var (
+		k    = s.key(p)
+		when = k.Day
+		size = int64(pathtm.PTHHeaderLen + pathtm.CCSDSHeaderLen + len(p.Data))
+	)
+	if set := (p.Pid >> 11) & 0x1; set != 0 {
+		size += pathtm.ESAHeaderLen
+	}
+	if s.interval > 0 {
+		when = p.Timestamp().Truncate(s.interval)
+	}
+	c, ok := s.slots[k]
+	if !ok || !c.when.Equal(when) {
+		c = &slot{when: when}
+		c.name = s.filename(c, p)
+		s.slots[k] = c
+	}
+	t := s.part(c.name)
+	for s.maxSize > 0 && t.size > 0 && t.size+size > s.maxSize {
+		c.seq++
+		c.name = s.filename(c, p)
+		t = s.part(c.name)
+	}
+	if err := s.openPart(t); err != nil {
+		return err
+	}
+	if err := t.enc.Encode(p); err != nil {
+		return err
+	}
+	t.size += size
+	return nil
+}
+
+// part gives the part written in the file name.
+func (s *splitter) part(name string) *part {
+	t, ok := s.parts[name]
+	if !ok {
+		t = &part{name: name}
+		s.parts[name] = t
+	}
+	return t
+}
+
+func (s *splitter) filename(c *slot, p pathtm.Packet) string {
+	pi := rt.PacketInfo{
+		Pid:  int(p.Apid()),
+		Sid:  int(p.Sid),
+		When: c.when,
+	}
+	if pi.When.IsZero() {
+		pi.When = p.Timestamp()
+	}
+	file := s.format.Format(pi)
+	r := strings.NewReplacer(
+		placeholderType, typeName(p.PacketType()),
+		placeholderSegment, p.Segmentation().String(),
+		placeholderPart, strconv.Itoa(c.seq),
+	)
+	file = r.Replace(file)
+	if c.seq > 0 && !strings.Contains(s.pattern, placeholderPart) {
+		file = fmt.Sprintf("%s.%d", file, c.seq)
+	}
+	return file
+}
+
+// typeName gives the name of the packet type t usable in a file name.
+func typeName(t pathtm.ESAPacketType) string {
+	if t == pathtm.Default {
+		return "default"
+	}
+	return strings.ReplaceAll(t.String(), " ", "-")
+}
+
+// openPart opens the file of t if needed, closing the least recently used file
+// when too many files are open. A file already written is opened again in
+// append mode.
+func (s *splitter) openPart(t *part) error {
+	if t.file != nil {
+		s.lru.MoveToFront(t.elem)
+		return nil
+	}
+	for s.lru.Len() >= s.maxOpen {
+		if err := s.closePart(s.lru.Back().Value.(*part)); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(t.name), 0755); err != nil {
+		return err
+	}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if t.size > 0 {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(t.name, flag, 0644)
+	if err != nil {
+		return err
+	}
+	t.file, t.enc = f, pathtm.NewEncoder(f, false)
+	t.elem = s.lru.PushFront(t)
+	return nil
+}
+
+func (s *splitter) closePart(t *part) error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.enc.Flush()
+	if e := t.file.Close(); err == nil {
+		err = e
+	}
+	s.lru.Remove(t.elem)
+	t.file, t.enc, t.elem = nil, nil, nil
+	return err
+}
+
+func (s *splitter) Close() error {
+	var err error
+	for _, t := range s.parts {
+		if e := s.closePart(t); err == nil {
+			err = e
+		}
+	}
+	return err
+}
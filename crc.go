@@ -0,0 +1,83 @@
+package pathtm
+
+import (
+	"encoding/binary"
+)
+
+const crcLen = 2
+
+var crcTable [256]uint16
+
+func init() {
+	for i := range crcTable {
+		c := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0x1021
+			} else {
+				c <<= 1
+			}
+		}
+		crcTable[i] = c
+	}
+}
+
+// CRC16 computes the CRC-16-CCITT (polynomial 0x1021, initial value 0xFFFF)
+// used as packet error control by CCSDS packets.
+func CRC16(bs []byte) uint16 {
+	c := uint16(0xFFFF)
+	for _, b := range bs {
+		c = c<<8 ^ crcTable[byte(c>>8)^b]
+	}
+	return c
+}
+
+type crcState uint8
+
+const (
+	crcUnchecked crcState = iota
+	crcValid
+	crcInvalid
+)
+
+// Valid reports whether the packet error control of the packet matches its
+// content. Packets whose packet error control has not been checked by the
+// Decoder are always valid.
+func (p Packet) Valid() bool {
+	return p.crc != crcInvalid
+}
+
+// Checksum makes the decoder verify the CRC-16-CCITT found in the last two
+// bytes of the user data of the packets having one of the given apids. Without
+// apids, the CRC of all packets is verified. The CRC found in the packet is
+// stored in its Sum field.
+func (d *Decoder) Checksum(apids ...int) {
+	set := make(map[uint16]struct{})
+	for _, a := range apids {
+		set[uint16(a)] = struct{}{}
+	}
+	d.checksum = func(apid uint16) bool {
+		if len(set) == 0 {
+			return true
+		}
+		_, ok := set[apid]
+		return ok
+	}
+}
+
+// checkSum verifies the CRC of the packet stored in body (starting at the
+// CCSDS primary header).
+func checkSum(p *Packet, body []byte) {
+	size := CCSDSHeaderLen + p.bodyLen()
+	if size > len(body) || size < CCSDSHeaderLen+crcLen {
+		p.crc = crcInvalid
+		return
+	}
+	sum := binary.BigEndian.Uint16(body[size-crcLen:])
+	p.Sum = uint32(sum)
+	if CRC16(body[:size-crcLen]) == sum {
+		p.crc = crcValid
+	} else {
+		p.crc = crcInvalid
+	}
+}
@@ -0,0 +1,65 @@
+package pathtm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  uint16
+	}{
+		{Input: "", Want: 0xFFFF},
+		{Input: "A", Want: 0xB915},
+		{Input: "123456789", Want: 0x29B1},
+	}
+	for _, d := range data {
+		if got := CRC16([]byte(d.Input)); got != d.Want {
+			t.Errorf("%q: crc mismatched: want %04x, got %04x", d.Input, d.Want, got)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		buf, err := testPacket(uint16(i+1), true, 32).Marshal()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sum := CRC16(buf[PTHHeaderLen : len(buf)-crcLen])
+		binary.BigEndian.PutUint16(buf[len(buf)-crcLen:], sum)
+		if i == 1 {
+			// flip one bit of the user data of the 2nd packet
+			buf[len(buf)-crcLen-5] ^= 0x10
+		}
+		stream = append(stream, buf...)
+	}
+	data := []struct {
+		Apids []int
+		Want  []bool
+	}{
+		{Apids: []int{1, 2, 3}, Want: []bool{true, false, true}},
+		{Apids: []int{1, 3}, Want: []bool{true, true, true}},
+		{Apids: nil, Want: []bool{true, false, true}},
+	}
+	for _, d := range data {
+		dec := NewDecoder(bytes.NewReader(stream), nil)
+		dec.Checksum(d.Apids...)
+		for i, want := range d.Want {
+			p, err := dec.Decode(true)
+			if err != nil {
+				t.Fatalf("%v: packet %d: unexpected error: %s", d.Apids, i+1, err)
+			}
+			if got := p.Valid(); got != want {
+				t.Errorf("%v: packet %d: valid mismatched: want %t, got %t", d.Apids, i+1, want, got)
+			}
+		}
+		if _, err := dec.Decode(true); err != io.EOF {
+			t.Errorf("%v: expected io.EOF, got %v", d.Apids, err)
+		}
+	}
+}
@@ -1,32 +1,69 @@
 package pathtm
 
 import (
+	"encoding/binary"
 	"io"
 	"time"
 )
 
+// pthLenSize is the number of bytes of the PTH size field. The value of this
+// field gives the number of bytes that follow it in the packet.
+const pthLenSize = 4
+
 type Decoder struct {
-	filter func(CCSDSHeader, ESAHeader) (bool, error)
-	inner  io.Reader
-	buffer []byte
+	framing Framing
+	filter  Filter
+	inner   io.Reader
+	buffer  []byte
+	offset  int
+	size    int
+
+	// position in the stream of the first byte at buffer[offset]
+	pos int64
+	// position in the stream of the last packet decoded
+	last int64
+
+	// files whose bytes have been read from the stream, starting with the
+	// file of the last packet decoded, and number of packets decoded from
+	// this file
+	files []fileMark
+	count int
+
+	resync   *resync
+	checksum func(uint16) bool
+
+	// set to io.EOF once the decoder has lost the framing of the stream
+	err error
+}
+
+// fileMark gives the position in the stream of the first byte of a file.
+type fileMark struct {
+	name string
+	pos  int64
+}
+
+func NewDecoder(r io.Reader, filter Filter) *Decoder {
+	return NewFramedDecoder(r, FramePTH, filter)
 }
 
-func NewDecoder(r io.Reader, filter func(CCSDSHeader, ESAHeader) (bool, error)) *Decoder {
+func NewFramedDecoder(r io.Reader, framing Framing, filter Filter) *Decoder {
+	if framing == FrameCADU {
+		r = newCADUReader(r)
+	}
 	if filter == nil {
-		filter = func(_ CCSDSHeader, _ ESAHeader) (bool, error) {
-			return true, nil
-		}
+		filter = Any()
 	}
 	return &Decoder{
-		filter: filter,
-		inner:  r,
-		buffer: make([]byte, BufferSize),
+		framing: framing,
+		filter:  filter,
+		inner:   r,
+		buffer:  make([]byte, BufferSize),
 	}
 }
 
 func (d *Decoder) Marshal() ([]byte, time.Time, error) {
-	p, err := d.Decode(true)
-	if err != nil {
+	var p Packet
+	if err := d.DecodeInto(&p); err != nil {
 		return nil, time.Time{}, err
 	}
 	buf, err := p.Marshal()
@@ -36,7 +73,7 @@ func (d *Decoder) Marshal() ([]byte, time.Time, error) {
 func (d *Decoder) Decode(data bool) (p Packet, err error) {
 	var ok bool
 	for {
-		p, ok, err = d.nextPacket(data)
+		ok, err = d.nextPacket(&p, data, false)
 		if ok || err != nil {
 			break
 		}
@@ -44,41 +81,338 @@ func (d *Decoder) Decode(data bool) (p Packet, err error) {
 	return
 }
 
-func (d *Decoder) nextPacket(data bool) (p Packet, keep bool, err error) {
-	var n int
-	if n, err = d.inner.Read(d.buffer); err != nil {
+// DecodeInto decodes the next packet into p without allocating. The Data of p
+// refers to the buffer of the decoder and is only valid until the next call to
+// the decoder.
+func (d *Decoder) DecodeInto(p *Packet) error {
+	for {
+		ok, err := d.nextPacket(p, true, true)
+		if ok || err != nil {
+			return err
+		}
+	}
+}
+
+// Offset gives the position in the stream of the first byte of the last packet
+// returned by Decode.
+func (d *Decoder) Offset() int64 {
+	return d.last
+}
+
+func (d *Decoder) nextPacket(p *Packet, data, view bool) (keep bool, err error) {
+	if d.err != nil {
+		return false, d.err
+	}
+	var body []byte
+	if body, err = d.nextFrame(); err != nil {
+		// without resync, the decoder can not find the next packet anymore:
+		// the error is given once and the decoder goes on with the next file
+		// or the stream ends.
+		if isCorrupted(err) || err == io.ErrUnexpectedEOF {
+			err = d.frameError(err)
+			if !d.nextFile() {
+				d.err = io.EOF
+			}
+		}
 		return
 	}
-	if p, err = decodePacket(d.buffer[:n], data); err != nil {
+	d.last = d.pos - int64(len(body))
+	m := d.locate(d.last)
+	d.count++
+	switch {
+	case d.framing == FramePTH && view:
+		*p, err = viewPacket(body)
+	case d.framing == FramePTH:
+		*p, err = decodePacket(body, data)
+	case view:
+		*p, err = viewRawPacket(body)
+	default:
+		*p, err = decodeRawPacket(body, data)
+	}
+	if err != nil {
+		if e, ok := err.(*DecodeError); ok {
+			e.File, e.Offset, e.Index = m.name, d.last-m.pos, d.count
+		}
 		return
 	}
-	keep, err = d.filter(p.CCSDSHeader, p.ESAHeader)
+	if d.checksum != nil && d.checksum(p.Apid()) {
+		if d.framing == FramePTH {
+			body = body[PTHHeaderLen:]
+		}
+		checkSum(p, body)
+	}
+	keep, err = d.filter(p.PTHHeader, p.CCSDSHeader, p.ESAHeader)
 	return
 }
 
+// nextFrame returns the bytes of the next packet found in the stream. The
+// returned slice is only valid until the next call to nextFrame.
+func (d *Decoder) nextFrame() ([]byte, error) {
+	var (
+		skip  int
+		cause error
+	)
+	for {
+		size, err := d.frameSize(skip > 0)
+		if err == nil {
+			if skip > 0 {
+				d.skipped(skip, cause)
+			}
+			if err = d.fill(size); err != nil {
+				return nil, err
+			}
+			if d.spanFiles(size) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			body := d.buffer[d.offset : d.offset+size]
+			if d.resync != nil {
+				d.resync.accept(body, d.framing)
+			}
+			d.offset += size
+			d.pos += int64(size)
+			return body, nil
+		}
+		if d.resync == nil || !isCorrupted(err) {
+			if skip > 0 {
+				d.skipped(skip, cause)
+			}
+			return nil, err
+		}
+		if skip == 0 {
+			cause = err
+		}
+		d.offset++
+		d.pos++
+		skip++
+	}
+}
+
+// skipped reports the n bytes skipped before the current position.
+func (d *Decoder) skipped(n int, err error) {
+	pos := d.pos - int64(n)
+	m := d.locate(pos)
+	d.resync.skipped(m.name, pos-m.pos, n, err)
+}
+
+func (d *Decoder) frameError(err error) error {
+	stage, size := StagePTH, PTHHeaderLen+CCSDSHeaderLen
+	if d.framing != FramePTH {
+		stage, size = StageCCSDS, CCSDSHeaderLen
+	}
+	end := d.size
+	if len(d.files) > 1 {
+		end = d.offset + int(d.files[1].pos-d.pos)
+	}
+	if err == io.ErrUnexpectedEOF && end-d.offset >= size {
+		stage = StageData
+	}
+	m := d.locate(d.pos)
+	e := headerError(stage, d.buffer[d.offset:end], size, err).(*DecodeError)
+	e.File, e.Offset, e.Index = m.name, d.pos-m.pos, d.count+1
+	return e
+}
+
+// spanFiles reports whether the n bytes at the current position go beyond the
+// end of the file holding the first of them: its last packet is truncated.
+func (d *Decoder) spanFiles(n int) bool {
+	d.locate(d.pos)
+	return len(d.files) > 1 && d.files[1].pos < d.pos+int64(n)
+}
+
+// nextFile discards the bytes left in the file at the current position. It
+// reports false when the reader does not report the files it reads or when
+// there is no file after this one.
+func (d *Decoder) nextFile() bool {
+	if len(d.files) == 0 || d.files[0].name == "" {
+		return false
+	}
+	for len(d.files) < 2 {
+		d.pos += int64(d.size - d.offset)
+		d.offset = d.size
+		if err := d.fill(1); err != nil {
+			return false
+		}
+	}
+	n := d.files[1].pos - d.pos
+	d.offset += int(n)
+	d.pos += n
+	d.files, d.count = d.files[1:], 0
+	return true
+}
+
+// source gives the name of the file currently read if the reader of the
+// decoder is able to report it.
+func (d *Decoder) source() string {
+	if n, ok := d.inner.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+// locate gives the file holding the byte at pos in the stream. The count of
+// packets is reset when pos is in a file after the one of the last packet.
+func (d *Decoder) locate(pos int64) fileMark {
+	if len(d.files) == 0 {
+		return fileMark{name: d.source()}
+	}
+	for len(d.files) > 1 && d.files[1].pos <= pos {
+		d.files, d.count = d.files[1:], 0
+	}
+	return d.files[0]
+}
+
+// frameSize gives the number of bytes of the packet starting at the current
+// position in the stream. When resynchronisation is enabled, the headers of
+// the packet are also checked for consistency.
+func (d *Decoder) frameSize(scan bool) (int, error) {
+	var (
+		size   int
+		header int
+	)
+	if d.framing == FramePTH {
+		if err := d.fill(pthLenSize); err != nil {
+			return 0, err
+		}
+		size = int(binary.LittleEndian.Uint32(d.buffer[d.offset:]))
+		if size < PTHHeaderLen-pthLenSize+CCSDSHeaderLen {
+			return 0, io.ErrShortBuffer
+		}
+		if size > PTHHeaderLen-pthLenSize+CCSDSHeaderLen+maxCCSDSLen {
+			return 0, ErrTooLarge
+		}
+		size += pthLenSize
+		header = PTHHeaderLen + CCSDSHeaderLen
+	} else {
+		if err := d.fill(CCSDSHeaderLen); err != nil {
+			return 0, err
+		}
+		size = CCSDSHeaderLen + int(binary.BigEndian.Uint16(d.buffer[d.offset+4:])) + 1
+		header = CCSDSHeaderLen
+	}
+	if d.resync == nil {
+		return size, nil
+	}
+	if err := d.fill(header); err != nil {
+		return 0, err
+	}
+	return size, d.resync.check(d.buffer[d.offset:d.offset+header], size, d.framing, scan)
+}
+
+// fill reads from the underlying reader until at least n bytes are available
+// in the buffer. The reader is always given at least BufferSize bytes of free
+// space so that readers returning one packet per Read keep working.
+func (d *Decoder) fill(n int) error {
+	for d.size-d.offset < n {
+		if d.offset > 0 {
+			d.size = copy(d.buffer, d.buffer[d.offset:d.size])
+			d.offset = 0
+		}
+		if free := len(d.buffer) - d.size; free < BufferSize || len(d.buffer) < n {
+			grow := len(d.buffer) + BufferSize
+			if grow < n+BufferSize {
+				grow = n + BufferSize
+			}
+			buf := make([]byte, grow)
+			copy(buf, d.buffer[:d.size])
+			d.buffer = buf
+		}
+		c, err := d.inner.Read(d.buffer[d.size:])
+		if c > 0 {
+			// a reader going through several files reports the file of
+			// the bytes it has just given.
+			name := d.source()
+			if n := len(d.files); n == 0 || d.files[n-1].name != name {
+				pos := d.pos + int64(d.size-d.offset)
+				d.files = append(d.files, fileMark{name: name, pos: pos})
+			}
+		}
+		d.size += c
+		if err == nil || d.size-d.offset >= n {
+			continue
+		}
+		if err == io.EOF && d.size > d.offset {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
 func DecodePacket(buffer []byte, data bool) (Packet, error) {
 	return decodePacket(buffer, data)
 }
 
 func decodePacket(body []byte, data bool) (p Packet, err error) {
-	var offset int
-	if p.PTHHeader, err = decodePTH(body[offset:]); err != nil {
+	if p, err = viewPacket(body); err == nil {
+		p.Data = copyData(p.Data, data)
+	}
+	return
+}
+
+// View decodes the packet found in body. The Data of p refers to body.
+func (p *Packet) View(body []byte) error {
+	k, err := viewPacket(body)
+	if err == nil {
+		*p = k
+	}
+	return err
+}
+
+func viewPacket(body []byte) (p Packet, err error) {
+	var pth PTHHeader
+	if pth, err = decodePTH(body); err != nil {
+		err = headerError(StagePTH, body, PTHHeaderLen, err)
 		return
 	}
-	offset += PTHHeaderLen
+	p, err = viewRawPacket(body[PTHHeaderLen:])
+	p.PTHHeader = pth
+	return
+}
+
+func DecodeRawPacket(buffer []byte, data bool) (Packet, error) {
+	return decodeRawPacket(buffer, data)
+}
+
+func decodeRawPacket(body []byte, data bool) (p Packet, err error) {
+	if p, err = viewRawPacket(body); err == nil {
+		p.Data = copyData(p.Data, data)
+	}
+	return
+}
+
+func copyData(body []byte, data bool) []byte {
+	if !data {
+		return nil
+	}
+	buf := make([]byte, len(body))
+	copy(buf, body)
+	return buf
+}
+
+func viewRawPacket(body []byte) (p Packet, err error) {
+	var offset int
 	if p.CCSDSHeader, err = decodeCCSDS(body[offset:]); err != nil {
+		err = headerError(StageCCSDS, body[offset:], CCSDSHeaderLen, err)
 		return
 	}
 	offset += CCSDSHeaderLen
+	size := p.bodyLen()
 	if set := (p.Pid >> 11) & 0x1; set != 0 {
+		if size < ESAHeaderLen {
+			err = headerError(StageCCSDS, body, CCSDSHeaderLen, ErrLength)
+			return
+		}
 		if p.ESAHeader, err = decodeESA(body[offset:]); err != nil {
+			err = headerError(StageESA, body[offset:], ESAHeaderLen, err)
 			return
 		}
 		offset += ESAHeaderLen
+		size -= ESAHeaderLen
 	}
-	if data {
-		p.Data = make([]byte, int(p.CCSDSHeader.Len()-ESAHeaderLen))
-		copy(p.Data, body[offset:])
+	if len(body)-offset < size {
+		err = headerError(StageData, body, offset, io.ErrUnexpectedEOF)
+		return
 	}
+	p.Data = body[offset : offset+size : offset+size]
 	return
 }
@@ -0,0 +1,178 @@
+package pathtm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestDecodeInto(t *testing.T) {
+	stream := testStream(t, 16, 256)
+	var (
+		d = NewDecoder(bytes.NewReader(stream), nil)
+		p Packet
+		n int
+	)
+	for {
+		err := d.DecodeInto(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := testPacket(uint16(n), true, 256)
+		if p.CCSDSHeader != want.CCSDSHeader || !bytes.Equal(p.Data, want.Data) {
+			t.Fatalf("packet %d mismatched", n)
+		}
+		n++
+	}
+	if n != 16 {
+		t.Errorf("packets count mismatched: want %d, got %d", 16, n)
+	}
+}
+
+func TestDecodeFrameError(t *testing.T) {
+	stream := testStream(t, 2, 32)
+	stream = append(stream, 1, 0, 0, 0, 0xFF, 0xFF)
+
+	d := NewDecoder(bytes.NewReader(stream), nil)
+	for i := 0; i < 2; i++ {
+		if _, err := d.Decode(true); err != nil {
+			t.Fatalf("packet %d: unexpected error: %s", i+1, err)
+		}
+	}
+	if _, err := d.Decode(true); err == nil || err == io.EOF {
+		t.Fatalf("corrupted packet: expected error, got %v", err)
+	}
+	if _, err := d.Decode(true); err != io.EOF {
+		t.Fatalf("after corrupted packet: expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeErrorLocation(t *testing.T) {
+	var (
+		first  = testStream(t, 3, 16)
+		second = testStream(t, 3, 16)
+		size   = len(second) / 3
+	)
+	// set an invalid version in the ccsds header of the 2nd packet
+	second[size+PTHHeaderLen] |= 0x20
+
+	r := multiReader{
+		names: []string{"a.dat", "b.dat"},
+		files: [][]byte{first, second},
+	}
+	d := NewDecoder(&r, nil)
+	for i := 0; i < 4; i++ {
+		if _, err := d.Decode(true); err != nil {
+			t.Fatalf("packet %d: unexpected error: %s", i+1, err)
+		}
+	}
+	_, err := d.Decode(true)
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+	if e.File != "b.dat" || e.Offset != int64(size) || e.Index != 2 || e.Stage != StageCCSDS {
+		t.Errorf("error location mismatched: want b.dat, packet 2 at offset %d, got %s", size, e)
+	}
+	if _, err := d.Decode(true); err != nil {
+		t.Errorf("packet after error: unexpected error: %s", err)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	stream := testStream(b, 1024, 1024)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewDecoder(bytes.NewReader(stream), nil)
+		for {
+			_, err := d.Decode(true)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeInto(b *testing.B) {
+	stream := testStream(b, 1024, 1024)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var (
+			d = NewDecoder(bytes.NewReader(stream), nil)
+			p Packet
+		)
+		for {
+			err := d.DecodeInto(&p)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkMarshalAppend(b *testing.B) {
+	var (
+		p   = testPacket(1234, true, 1024)
+		buf []byte
+		err error
+	)
+	b.ReportAllocs()
+	b.SetBytes(int64(PTHHeaderLen + CCSDSHeaderLen + int(p.Length) + 1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = p.MarshalAppend(buf[:0]); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+// testStream gives the bytes of count packets with size bytes of data and
+// with their apid set to their ordinal.
+func testStream(tb testing.TB, count, size int) []byte {
+	tb.Helper()
+	var buf []byte
+	for i := 0; i < count; i++ {
+		var err error
+		if buf, err = testPacket(uint16(i), true, size).MarshalAppend(buf); err != nil {
+			tb.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return buf
+}
+
+// multiReader gives the bytes of several files one after the other and reports
+// the name of the file of the last bytes read.
+type multiReader struct {
+	names []string
+	files [][]byte
+	name  string
+}
+
+func (m *multiReader) Read(b []byte) (int, error) {
+	for len(m.files) > 0 && len(m.files[0]) == 0 {
+		m.names, m.files = m.names[1:], m.files[1:]
+	}
+	if len(m.files) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, m.files[0])
+	m.name, m.files[0] = m.names[0], m.files[0][n:]
+	return n, nil
+}
+
+func (m *multiReader) Name() string {
+	return m.name
+}
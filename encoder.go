@@ -0,0 +1,70 @@
+package pathtm
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrTooLarge = errors.New("packet too large")
+
+// gps is the reference epoch of the 5 bytes PTH coarse/fine time
+var gps = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
+
+const maxCCSDSLen = 1 << 16
+
+type Encoder struct {
+	stamp  bool
+	inner  *bufio.Writer
+	buffer []byte
+}
+
+func NewEncoder(w io.Writer, stamp bool) *Encoder {
+	return &Encoder{
+		stamp: stamp,
+		inner: bufio.NewWriterSize(w, BufferSize),
+	}
+}
+
+func (e *Encoder) Encode(p Packet) error {
+	if len(p.Data) == 0 {
+		return ErrEmpty
+	}
+	size := len(p.Data)
+	if set := (p.CCSDSHeader.Pid >> 11) & 0x1; set != 0 {
+		size += ESAHeaderLen
+	}
+	if size > maxCCSDSLen {
+		return ErrTooLarge
+	}
+	p.CCSDSHeader.Length = uint16(size - 1)
+	// size of the PTH header does not include its own 4 bytes length field
+	p.PTHHeader.Size = uint32(PTHHeaderLen - 4 + CCSDSHeaderLen + size)
+	if e.stamp {
+		p.PTHHeader.Coarse, p.PTHHeader.Fine = SplitTime(time.Now())
+	}
+	buf, err := p.MarshalAppend(e.buffer[:0])
+	if err != nil {
+		return err
+	}
+	e.buffer = buf
+	_, err = e.inner.Write(buf)
+	return err
+}
+
+func (e *Encoder) Flush() error {
+	return e.inner.Flush()
+}
+
+// SplitTime gives the coarse and fine parts of t as found in the 5 bytes times
+// of the PTH and ESA headers.
+func SplitTime(t time.Time) (uint32, uint8) {
+	delta := t.Sub(gps)
+	if delta < 0 {
+		return 0, 0
+	}
+	coarse := delta / time.Second
+	fine := (delta % time.Second) * 256 / time.Second
+	return uint32(coarse), uint8(fine)
+}
@@ -0,0 +1,86 @@
+package pathtm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestEncoder(t *testing.T) {
+	data := []struct {
+		Name string
+		Packet
+		Err error
+	}{
+		{Name: "esa", Packet: testPacket(1234, true, 32)},
+		{Name: "no-esa", Packet: testPacket(1234, false, 32)},
+		{Name: "largest-esa", Packet: testPacket(42, true, maxCCSDSLen-ESAHeaderLen)},
+		{Name: "largest", Packet: testPacket(42, false, maxCCSDSLen)},
+		{Name: "too-large-esa", Packet: testPacket(42, true, maxCCSDSLen-ESAHeaderLen+1), Err: ErrTooLarge},
+		{Name: "too-large", Packet: testPacket(42, false, maxCCSDSLen+1), Err: ErrTooLarge},
+		{Name: "empty", Packet: testPacket(42, false, 0), Err: ErrEmpty},
+	}
+	var (
+		buf   bytes.Buffer
+		want  []Packet
+		names []string
+		e     = NewEncoder(&buf, false)
+	)
+	for _, d := range data {
+		p := d.Packet
+		// the lengths are recomputed by the encoder
+		p.Length, p.PTHHeader.Size = 0, 0
+		if err := e.Encode(p); err != d.Err {
+			t.Errorf("%s: unexpected error: want %v, got %v", d.Name, d.Err, err)
+		}
+		if d.Err == nil {
+			want, names = append(want, d.Packet), append(names, d.Name)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dec := NewDecoder(&buf, nil)
+	for i, w := range want {
+		p, err := dec.Decode(true)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", names[i], err)
+		}
+		if p.PTHHeader != w.PTHHeader || p.CCSDSHeader != w.CCSDSHeader || p.ESAHeader != w.ESAHeader {
+			t.Errorf("%s: headers mismatched: want %+v, got %+v", names[i], w, p)
+		}
+		if !bytes.Equal(p.Data, w.Data) {
+			t.Errorf("%s: data mismatched", names[i])
+		}
+	}
+	if _, err := dec.Decode(true); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEncoderStamp(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		e   = NewEncoder(&buf, true)
+		p   = testPacket(42, true, 32)
+	)
+	first, _ := SplitTime(time.Now())
+	if err := e.Encode(p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	last, _ := SplitTime(time.Now())
+	if err := e.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := DecodePacket(buf.Bytes(), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.PTHHeader.Coarse < first || got.PTHHeader.Coarse > last {
+		t.Errorf("pth time not stamped: want between %d and %d, got %d", first, last, got.PTHHeader.Coarse)
+	}
+	if got.ESAHeader != p.ESAHeader {
+		t.Errorf("esa header mismatched: want %+v, got %+v", p.ESAHeader, got.ESAHeader)
+	}
+}
@@ -0,0 +1,73 @@
+package pathtm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Stage uint8
+
+const (
+	StagePTH Stage = iota
+	StageCCSDS
+	StageESA
+	StageData
+)
+
+func (s Stage) String() string {
+	switch s {
+	default:
+		return "***"
+	case StagePTH:
+		return "pth"
+	case StageCCSDS:
+		return "ccsds"
+	case StageESA:
+		return "esa"
+	case StageData:
+		return "data"
+	}
+}
+
+// DecodeError gives the location of the data that could not be decoded.
+//
+// File is only set when the reader given to the Decoder has a Name method
+// reporting the file being currently read. Offset is the position of the first
+// byte of the packet in this file (in the stream otherwise) and Index is its
+// ordinal in the file (starting at 1). Header holds the raw bytes of the header that failed to decode.
+type DecodeError struct {
+	File   string
+	Offset int64
+	Index  int
+	Stage  Stage
+	Header []byte
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	var str strings.Builder
+	if e.File != "" {
+		fmt.Fprintf(&str, "%s: ", e.File)
+	}
+	fmt.Fprintf(&str, "packet %d (offset %d): %s header", e.Index, e.Offset, e.Stage)
+	if len(e.Header) > 0 {
+		fmt.Fprintf(&str, " [% x]", e.Header)
+	}
+	fmt.Fprintf(&str, ": %s", e.Err)
+	return str.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func headerError(s Stage, body []byte, size int, err error) error {
+	if len(body) < size {
+		size = len(body)
+	}
+	return &DecodeError{
+		Stage:  s,
+		Header: append([]byte(nil), body[:size]...),
+		Err:    err,
+	}
+}
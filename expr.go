@@ -0,0 +1,500 @@
+package pathtm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FilterError reports a syntax error in a filter expression. Pos is the
+// position (starting at 1) of the offending token in the expression.
+type FilterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter: column %d: %s", e.Pos, e.Msg)
+}
+
+// ParseFilter compiles a filter expression into a Filter.
+//
+// An expression is made of comparisons between a field and a value combined
+// with and, or, not and parentheses:
+//
+//	apid in (850, 851) and sid > 100 and type = "science data" and time >= 2024-01-01T00:00:00
+//
+// The available fields are:
+//
+//	apid, sid, sequence, length: compared to numbers
+//	type, class, segment: compared to strings (eg: "science data", "dat", "first")
+//	time, recv: compared to times (ESA generation and PTH reception time)
+//
+// The operators are =, !=, <, <=, >, >=, in and not in. Strings can only be
+// compared with =, != and (not) in.
+func ParseFilter(str string) (Filter, error) {
+	p := parser{scan: newScanner(str)}
+	p.next()
+
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.curr.kind != tokEOF {
+		return nil, p.errorf("unexpected %s after expression", p.curr)
+	}
+	return f, nil
+}
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokTime
+	tokOperator
+	tokLparen
+	tokRparen
+	tokComma
+	tokInvalid
+)
+
+type token struct {
+	kind    tokenKind
+	literal string
+	pos     int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.literal)
+	default:
+		return fmt.Sprintf("%q", t.literal)
+	}
+}
+
+type scanner struct {
+	input []rune
+	pos   int
+}
+
+func newScanner(str string) *scanner {
+	return &scanner{input: []rune(str)}
+}
+
+func (s *scanner) scan() token {
+	for s.pos < len(s.input) && unicode.IsSpace(s.input[s.pos]) {
+		s.pos++
+	}
+	if s.pos >= len(s.input) {
+		return token{kind: tokEOF, pos: s.pos + 1}
+	}
+	var (
+		pos = s.pos
+		r   = s.input[s.pos]
+	)
+	switch {
+	case r == '(':
+		s.pos++
+		return token{kind: tokLparen, literal: "(", pos: pos + 1}
+	case r == ')':
+		s.pos++
+		return token{kind: tokRparen, literal: ")", pos: pos + 1}
+	case r == ',':
+		s.pos++
+		return token{kind: tokComma, literal: ",", pos: pos + 1}
+	case r == '"':
+		return s.scanString()
+	case strings.ContainsRune("=!<>", r):
+		return s.scanOperator()
+	case unicode.IsDigit(r):
+		return s.scanValue()
+	case unicode.IsLetter(r) || r == '_':
+		for s.pos < len(s.input) && (unicode.IsLetter(s.input[s.pos]) || unicode.IsDigit(s.input[s.pos]) || s.input[s.pos] == '_') {
+			s.pos++
+		}
+		return token{kind: tokIdent, literal: string(s.input[pos:s.pos]), pos: pos + 1}
+	default:
+		s.pos++
+		return token{kind: tokInvalid, literal: string(r), pos: pos + 1}
+	}
+}
+
+func (s *scanner) scanString() token {
+	pos := s.pos
+	for s.pos++; s.pos < len(s.input); s.pos++ {
+		if s.input[s.pos] == '"' {
+			s.pos++
+			return token{kind: tokString, literal: string(s.input[pos+1 : s.pos-1]), pos: pos + 1}
+		}
+	}
+	return token{kind: tokInvalid, literal: string(s.input[pos:]), pos: pos + 1}
+}
+
+func (s *scanner) scanOperator() token {
+	pos := s.pos
+	s.pos++
+	if s.pos < len(s.input) && s.input[s.pos] == '=' {
+		s.pos++
+	}
+	str := string(s.input[pos:s.pos])
+	if str == "!" {
+		return token{kind: tokInvalid, literal: str, pos: pos + 1}
+	}
+	return token{kind: tokOperator, literal: str, pos: pos + 1}
+}
+
+// scanValue scans numbers and times. Times are written without quotes (eg:
+// 2024-01-01T00:00:00 or 2024-01-01).
+func (s *scanner) scanValue() token {
+	pos := s.pos
+	for s.pos < len(s.input) {
+		r := s.input[s.pos]
+		if !unicode.IsDigit(r) && !unicode.IsLetter(r) && !strings.ContainsRune("-:.+", r) {
+			break
+		}
+		s.pos++
+	}
+	str := string(s.input[pos:s.pos])
+	if _, err := strconv.ParseInt(str, 0, 64); err == nil {
+		return token{kind: tokNumber, literal: str, pos: pos + 1}
+	}
+	if _, err := parseTime(str); err == nil {
+		return token{kind: tokTime, literal: str, pos: pos + 1}
+	}
+	return token{kind: tokInvalid, literal: str, pos: pos + 1}
+}
+
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseTime(str string) (time.Time, error) {
+	for _, f := range timeFormats {
+		if t, err := time.Parse(f, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", str)
+}
+
+type fieldKind uint8
+
+const (
+	kindNumber fieldKind = iota
+	kindString
+	kindTime
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	default:
+		return "***"
+	case kindNumber:
+		return "number"
+	case kindString:
+		return "string"
+	case kindTime:
+		return "time"
+	}
+}
+
+type field struct {
+	kind   fieldKind
+	number func(PTHHeader, CCSDSHeader, ESAHeader) int64
+	string func(PTHHeader, CCSDSHeader, ESAHeader) string
+	time   func(PTHHeader, CCSDSHeader, ESAHeader) time.Time
+}
+
+var fields = map[string]field{
+	"apid": {
+		kind:   kindNumber,
+		number: func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) int64 { return int64(c.Apid()) },
+	},
+	"sid": {
+		kind:   kindNumber,
+		number: func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) int64 { return int64(e.Sid) },
+	},
+	"sequence": {
+		kind:   kindNumber,
+		number: func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) int64 { return int64(c.Sequence()) },
+	},
+	"length": {
+		kind:   kindNumber,
+		number: func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) int64 { return int64(c.Len()) },
+	},
+	"type": {
+		kind:   kindString,
+		string: func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) string { return e.PacketType().String() },
+	},
+	"class": {
+		kind:   kindString,
+		string: func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) string { return e.PacketType().Type() },
+	},
+	"segment": {
+		kind:   kindString,
+		string: func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) string { return c.Segmentation().String() },
+	},
+	"time": {
+		kind: kindTime,
+		time: func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) time.Time { return e.Timestamp() },
+	},
+	"recv": {
+		kind: kindTime,
+		time: func(h PTHHeader, _ CCSDSHeader, _ ESAHeader) time.Time { return h.Timestamp() },
+	},
+}
+
+// value is a literal of an expression converted to the kind of the field it
+// is compared to.
+type value struct {
+	number int64
+	string string
+	time   time.Time
+}
+
+type parser struct {
+	scan *scanner
+	curr token
+}
+
+func (p *parser) next() {
+	p.curr = p.scan.scan()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &FilterError{
+		Pos: p.curr.pos,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	return p.curr.kind == tokIdent && strings.EqualFold(p.curr.literal, kw)
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	f, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	fs := []Filter{f}
+	for p.isKeyword("or") {
+		p.next()
+		if f, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	if len(fs) == 1 {
+		return fs[0], nil
+	}
+	return Or(fs...), nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	f, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	fs := []Filter{f}
+	for p.isKeyword("and") {
+		p.next()
+		if f, err = p.parseNot(); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	if len(fs) == 1 {
+		return fs[0], nil
+	}
+	return And(fs...), nil
+}
+
+func (p *parser) parseNot() (Filter, error) {
+	switch {
+	case p.isKeyword("not"):
+		p.next()
+		f, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not(f), nil
+	case p.curr.kind == tokLparen:
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.curr.kind != tokRparen {
+			return nil, p.errorf("expected \")\", got %s", p.curr)
+		}
+		p.next()
+		return f, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *parser) parseComparison() (Filter, error) {
+	if p.curr.kind != tokIdent {
+		return nil, p.errorf("expected field name, got %s", p.curr)
+	}
+	name := strings.ToLower(p.curr.literal)
+	fd, ok := fields[name]
+	if !ok {
+		return nil, p.errorf("unknown field %q", p.curr.literal)
+	}
+	p.next()
+
+	var negate bool
+	if p.isKeyword("not") {
+		negate = true
+		p.next()
+		if !p.isKeyword("in") {
+			return nil, p.errorf("expected \"in\" after \"not\", got %s", p.curr)
+		}
+	}
+	if p.isKeyword("in") {
+		p.next()
+		vs, err := p.parseList(fd)
+		if err != nil {
+			return nil, err
+		}
+		f := fd.in(vs)
+		if negate {
+			f = Not(f)
+		}
+		return f, nil
+	}
+	if p.curr.kind != tokOperator {
+		return nil, p.errorf("expected operator after %q, got %s", name, p.curr)
+	}
+	op := p.curr
+	if fd.kind == kindString && op.literal != "=" && op.literal != "!=" {
+		return nil, p.errorf("operator %q can not be used with %q", op.literal, name)
+	}
+	p.next()
+	v, err := p.parseValue(fd)
+	if err != nil {
+		return nil, err
+	}
+	return fd.compare(op.literal, v), nil
+}
+
+func (p *parser) parseList(fd field) ([]value, error) {
+	if p.curr.kind != tokLparen {
+		return nil, p.errorf("expected \"(\" after \"in\", got %s", p.curr)
+	}
+	p.next()
+
+	var vs []value
+	for {
+		v, err := p.parseValue(fd)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+		switch p.curr.kind {
+		case tokComma:
+			p.next()
+		case tokRparen:
+			p.next()
+			return vs, nil
+		default:
+			return nil, p.errorf("expected \",\" or \")\", got %s", p.curr)
+		}
+	}
+}
+
+func (p *parser) parseValue(fd field) (value, error) {
+	var v value
+	switch {
+	case fd.kind == kindNumber && p.curr.kind == tokNumber:
+		v.number, _ = strconv.ParseInt(p.curr.literal, 0, 64)
+	case fd.kind == kindString && p.curr.kind == tokString:
+		v.string = p.curr.literal
+	case fd.kind == kindTime && (p.curr.kind == tokTime || p.curr.kind == tokString):
+		t, err := parseTime(p.curr.literal)
+		if err != nil {
+			return v, p.errorf("%s", err)
+		}
+		v.time = t
+	case p.curr.kind == tokInvalid:
+		return v, p.errorf("invalid value %s", p.curr)
+	default:
+		return v, p.errorf("expected %s value, got %s", fd.kind, p.curr)
+	}
+	p.next()
+	return v, nil
+}
+
+func (fd field) in(vs []value) Filter {
+	fs := make([]Filter, len(vs))
+	for i := range vs {
+		fs[i] = fd.compare("=", vs[i])
+	}
+	return Or(fs...)
+}
+
+func (fd field) compare(op string, v value) Filter {
+	return func(h PTHHeader, c CCSDSHeader, e ESAHeader) (bool, error) {
+		var cmp int
+		switch fd.kind {
+		case kindNumber:
+			cmp = compareInt(fd.number(h, c, e), v.number)
+		case kindString:
+			cmp = strings.Compare(fd.string(h, c, e), v.string)
+		case kindTime:
+			cmp = compareTime(fd.time(h, c, e), v.time)
+		}
+		switch op {
+		case "=", "==":
+			return cmp == 0, nil
+		case "!=":
+			return cmp != 0, nil
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		case ">=":
+			return cmp >= 0, nil
+		default:
+			return false, fmt.Errorf("filter: unknown operator %q", op)
+		}
+	}
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
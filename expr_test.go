@@ -0,0 +1,90 @@
+package pathtm
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	var (
+		h PTHHeader
+		c = CCSDSHeader{Pid: 1<<11 | 850, Fragment: 3<<14 | 42, Length: 99}
+		e = ESAHeader{Sid: 101, Info: uint8(ScienceData)}
+	)
+	when := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.Coarse, e.Fine = SplitTime(when)
+	h.Coarse, h.Fine = SplitTime(when.Add(time.Minute))
+
+	data := []struct {
+		Expr string
+		Want bool
+	}{
+		{Expr: "apid = 850", Want: true},
+		{Expr: "apid == 0x352", Want: true},
+		{Expr: "apid != 850", Want: false},
+		{Expr: "sid > 100 and sid <= 101", Want: true},
+		{Expr: "sequence < 42 or length >= 100", Want: true},
+		{Expr: "apid in (851, 850)", Want: true},
+		{Expr: "apid not in (851, 850)", Want: false},
+		{Expr: "not apid in (851)", Want: true},
+		{Expr: "APID = 850 AND Sid = 101", Want: true},
+		{Expr: `type = "science data" and class = "dat"`, Want: true},
+		{Expr: `segment in ("first", "last")`, Want: false},
+		{Expr: "(apid = 851 or sid = 101) and not (sequence = 42)", Want: false},
+		{Expr: "apid = 851 or (sid = 101 and sequence = 42)", Want: true},
+		{Expr: "time >= 2024-01-01 and time < 2024-01-02", Want: true},
+		{Expr: "time = 2024-01-01T12:00:00", Want: true},
+		{Expr: `time > "2024-01-01T12:00:00Z"`, Want: false},
+		{Expr: "recv > 2024-01-01T12:00:00.5", Want: true},
+	}
+	for _, d := range data {
+		f, err := ParseFilter(d.Expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Expr, err)
+			continue
+		}
+		got, err := f(h, c, e)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Expr, err)
+			continue
+		}
+		if got != d.Want {
+			t.Errorf("%s: want %t, got %t", d.Expr, d.Want, got)
+		}
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	data := []struct {
+		Expr string
+		Pos  int
+		Msg  string
+	}{
+		{Expr: "", Pos: 1, Msg: "expected field name"},
+		{Expr: "apd = 1", Pos: 1, Msg: `unknown field "apd"`},
+		{Expr: "apid 1", Pos: 6, Msg: `expected operator after "apid"`},
+		{Expr: "apid = ", Pos: 8, Msg: "expected number value"},
+		{Expr: `apid = "850"`, Pos: 8, Msg: "expected number value"},
+		{Expr: `type < "science data"`, Pos: 6, Msg: `operator "<" can not be used with "type"`},
+		{Expr: "apid not (1)", Pos: 10, Msg: `expected "in" after "not"`},
+		{Expr: "apid in 1", Pos: 9, Msg: `expected "(" after "in"`},
+		{Expr: "apid in (1 2)", Pos: 12, Msg: `expected "," or ")"`},
+		{Expr: "(apid = 1", Pos: 10, Msg: `expected ")"`},
+		{Expr: "apid = 1 sid = 2", Pos: 10, Msg: "unexpected"},
+		{Expr: "time > 2024-13-01", Pos: 8, Msg: "invalid value"},
+		{Expr: `type = "science`, Pos: 8, Msg: "invalid value"},
+		{Expr: "apid ! 1", Pos: 6, Msg: "expected operator"},
+	}
+	for _, d := range data {
+		_, err := ParseFilter(d.Expr)
+		e, ok := err.(*FilterError)
+		if !ok {
+			t.Errorf("%q: expected FilterError, got %v", d.Expr, err)
+			continue
+		}
+		if e.Pos != d.Pos || !strings.Contains(e.Msg, d.Msg) {
+			t.Errorf("%q: want column %d (%s), got %s", d.Expr, d.Pos, d.Msg, e)
+		}
+	}
+}
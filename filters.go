@@ -1,15 +1,173 @@
 package pathtm
 
-func WithApid(apid int) func(CCSDSHeader, ESAHeader) (bool, error) {
+import "time"
+
+// Filter reports whether a packet should be kept by a Decoder.
+type Filter func(PTHHeader, CCSDSHeader, ESAHeader) (bool, error)
+
+// Any keeps all packets.
+func Any() Filter {
+	return func(_ PTHHeader, _ CCSDSHeader, _ ESAHeader) (bool, error) {
+		return true, nil
+	}
+}
+
+// And keeps packets accepted by all the given filters.
+func And(fs ...Filter) Filter {
+	return func(h PTHHeader, c CCSDSHeader, e ESAHeader) (bool, error) {
+		for _, f := range fs {
+			if ok, err := f(h, c, e); !ok || err != nil {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// Or keeps packets accepted by at least one of the given filters. Without
+// filter, all packets are kept.
+func Or(fs ...Filter) Filter {
+	if len(fs) == 0 {
+		return Any()
+	}
+	return func(h PTHHeader, c CCSDSHeader, e ESAHeader) (bool, error) {
+		for _, f := range fs {
+			if ok, err := f(h, c, e); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// Not keeps packets rejected by the given filter.
+func Not(f Filter) Filter {
+	return func(h PTHHeader, c CCSDSHeader, e ESAHeader) (bool, error) {
+		ok, err := f(h, c, e)
+		return !ok, err
+	}
+}
+
+func WithApid(apid int) Filter {
 	i := uint16(apid)
-	return func(c CCSDSHeader, _ ESAHeader) (bool, error) {
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
 		return (i <= 0 || i == c.Apid()), nil
 	}
 }
 
-func WithSid(sid int) func(CCSDSHeader, ESAHeader) (bool, error) {
+func WithApids(apids ...int) Filter {
+	set := make(map[uint16]struct{})
+	for _, a := range apids {
+		if a > 0 {
+			set[uint16(a)] = struct{}{}
+		}
+	}
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
+		if len(set) == 0 {
+			return true, nil
+		}
+		_, ok := set[c.Apid()]
+		return ok, nil
+	}
+}
+
+func WithApidRange(first, last int) Filter {
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
+		a := int(c.Apid())
+		return a >= first && a <= last, nil
+	}
+}
+
+func WithSid(sid int) Filter {
 	i := uint32(sid)
-	return func(_ CCSDSHeader, e ESAHeader) (bool, error) {
+	return func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) (bool, error) {
 		return (i <= 0 || i == e.Sid), nil
 	}
 }
+
+func WithSids(sids ...int) Filter {
+	set := make(map[uint32]struct{})
+	for _, s := range sids {
+		if s > 0 {
+			set[uint32(s)] = struct{}{}
+		}
+	}
+	return func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) (bool, error) {
+		if len(set) == 0 {
+			return true, nil
+		}
+		_, ok := set[e.Sid]
+		return ok, nil
+	}
+}
+
+func WithPacketType(types ...ESAPacketType) Filter {
+	return func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) (bool, error) {
+		pt := e.PacketType()
+		for _, t := range types {
+			if t == pt {
+				return true, nil
+			}
+		}
+		return len(types) == 0, nil
+	}
+}
+
+// WithTypeClass keeps packets whose ESA packet type belongs to the given class
+// (dat, cmd or evt).
+func WithTypeClass(class string) Filter {
+	return func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) (bool, error) {
+		return e.PacketType().Type() == class, nil
+	}
+}
+
+func WithSegmentation(segs ...CCSDSSegment) Filter {
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
+		sg := c.Segmentation()
+		for _, s := range segs {
+			if s == sg {
+				return true, nil
+			}
+		}
+		return len(segs) == 0, nil
+	}
+}
+
+func WithSequenceRange(first, last int) Filter {
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
+		s := int(c.Sequence())
+		return s >= first && s <= last, nil
+	}
+}
+
+// WithLengthRange keeps packets whose length, as given by CCSDSHeader.Len, is
+// in the range [min, max].
+func WithLengthRange(min, max int) Filter {
+	return func(_ PTHHeader, c CCSDSHeader, _ ESAHeader) (bool, error) {
+		n := int(c.Len())
+		return n >= min && n <= max, nil
+	}
+}
+
+// Between keeps packets whose ESA generation time is in the range [start, end).
+// A zero start or end leaves the range open on that side.
+func Between(start, end time.Time) Filter {
+	return func(_ PTHHeader, _ CCSDSHeader, e ESAHeader) (bool, error) {
+		return inRange(e.Timestamp(), start, end), nil
+	}
+}
+
+// ReceivedBetween keeps packets whose PTH reception time is in the range
+// [start, end). A zero start or end leaves the range open on that side.
+func ReceivedBetween(start, end time.Time) Filter {
+	return func(h PTHHeader, _ CCSDSHeader, _ ESAHeader) (bool, error) {
+		return inRange(h.Timestamp(), start, end), nil
+	}
+}
+
+func inRange(t, start, end time.Time) bool {
+	if !start.IsZero() && t.Before(start) {
+		return false
+	}
+	return end.IsZero() || t.Before(end)
+}
@@ -0,0 +1,141 @@
+package pathtm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrSync = errors.New("invalid CADU sync marker")
+
+const (
+	CADULen       = 1024
+	ASMLen        = 4
+	VCDUHeaderLen = 6
+	MPDUHeaderLen = 2
+)
+
+const (
+	asm     = 0x1ACFFC1D
+	idleVC  = 0x3F
+	idlePid = 0x07FF
+	noFirst = 0x07FF
+	idleFHP = 0x07FE
+)
+
+// Framing tells a Decoder how packets are delimited in its input.
+type Framing uint8
+
+const (
+	// PTH: each CCSDS packet is preceded by a PTH header.
+	FramePTH Framing = iota
+	// CCSDS: packets follow each other without any extra header.
+	FrameCCSDS
+	// CADU: packets are carried in the packet zone of fixed length CADUs
+	// (sync marker, VCDU primary header and M_PDU header).
+	FrameCADU
+)
+
+func (f Framing) String() string {
+	switch f {
+	default:
+		return "***"
+	case FramePTH:
+		return "pth"
+	case FrameCCSDS:
+		return "ccsds"
+	case FrameCADU:
+		return "cadu"
+	}
+}
+
+// caduReader extracts the CCSDS packets carried by a stream of CADUs. Packets
+// of each virtual channel are reassembled separately and only complete packets
+// are returned by Read. When a frame of a virtual channel is lost, the pending
+// bytes of that channel are dropped and the channel waits for the next frame
+// with a first header pointer to start again.
+type caduReader struct {
+	inner io.Reader
+	frame []byte
+	queue []byte
+	vcs   map[uint8]*vcState
+}
+
+type vcState struct {
+	counter uint32
+	synced  bool
+	pending []byte
+}
+
+func newCADUReader(r io.Reader) *caduReader {
+	return &caduReader{
+		inner: r,
+		frame: make([]byte, CADULen),
+		vcs:   make(map[uint8]*vcState),
+	}
+}
+
+func (r *caduReader) Read(bs []byte) (int, error) {
+	for len(r.queue) == 0 {
+		if err := r.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(bs, r.queue)
+	r.queue = r.queue[n:]
+	return n, nil
+}
+
+func (r *caduReader) nextFrame() error {
+	if _, err := io.ReadFull(r.inner, r.frame); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(r.frame) != asm {
+		return ErrSync
+	}
+	frame := r.frame[ASMLen:]
+
+	vcid := frame[1] & 0x3F
+	if vcid == idleVC {
+		return nil
+	}
+	counter := binary.BigEndian.Uint32(frame[1:]) & 0xFFFFFF
+	first := int(binary.BigEndian.Uint16(frame[VCDUHeaderLen:]) & 0x07FF)
+	zone := frame[VCDUHeaderLen+MPDUHeaderLen:]
+
+	vc, ok := r.vcs[vcid]
+	if !ok {
+		vc = &vcState{}
+		r.vcs[vcid] = vc
+	}
+	if ok && (vc.counter+1)&0xFFFFFF != counter {
+		vc.synced, vc.pending = false, vc.pending[:0]
+	}
+	vc.counter = counter
+
+	if first == idleFHP {
+		return nil
+	}
+	if !vc.synced {
+		if first == noFirst || first >= len(zone) {
+			return nil
+		}
+		vc.synced, zone = true, zone[first:]
+	}
+	vc.pending = append(vc.pending, zone...)
+
+	var offset int
+	for len(vc.pending)-offset >= CCSDSHeaderLen {
+		packet := vc.pending[offset:]
+		size := CCSDSHeaderLen + int(binary.BigEndian.Uint16(packet[4:])) + 1
+		if len(packet) < size {
+			break
+		}
+		if pid := binary.BigEndian.Uint16(packet) & 0x07FF; pid != idlePid {
+			r.queue = append(r.queue, packet[:size]...)
+		}
+		offset += size
+	}
+	vc.pending = vc.pending[:copy(vc.pending, vc.pending[offset:])]
+	return nil
+}
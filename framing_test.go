@@ -0,0 +1,174 @@
+package pathtm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+const zoneLen = CADULen - ASMLen - VCDUHeaderLen - MPDUHeaderLen
+
+func TestFramedCCSDS(t *testing.T) {
+	var (
+		stream []byte
+		want   []Packet
+	)
+	for i, size := range []int{1, 32, 1000, maxCCSDSLen - ESAHeaderLen, 7} {
+		p := testPacket(uint16(i), i%2 == 1, size)
+		p.PTHHeader = PTHHeader{}
+		buf, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		stream = append(stream, buf[PTHHeaderLen:]...)
+		want = append(want, p)
+	}
+	d := NewFramedDecoder(bytes.NewReader(stream), FrameCCSDS, nil)
+	for i, w := range want {
+		p, err := d.Decode(true)
+		if err != nil {
+			t.Fatalf("packet %d: unexpected error: %s", i+1, err)
+		}
+		if p.PTHHeader != w.PTHHeader || p.CCSDSHeader != w.CCSDSHeader || p.ESAHeader != w.ESAHeader {
+			t.Errorf("packet %d: headers mismatched: want %+v, got %+v", i+1, w, p)
+		}
+		if !bytes.Equal(p.Data, w.Data) {
+			t.Errorf("packet %d: data mismatched", i+1)
+		}
+	}
+	if _, err := d.Decode(true); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestFramedCADU(t *testing.T) {
+	var (
+		vc1 = newTestChannel(1, 0, 20)
+		vc2 = newTestChannel(2, 0xFFFFFE, 12)
+	)
+	// the 3rd frame of vc1 is lost: the packets ending in it or starting in
+	// it are lost.
+	const lost = 2
+
+	var stream []byte
+	for i := 0; i < len(vc1.frames) || i < len(vc2.frames); i++ {
+		if i < len(vc1.frames) && i != lost {
+			stream = append(stream, vc1.frames[i]...)
+		}
+		stream = append(stream, idleFrame()...)
+		if i < len(vc2.frames) {
+			stream = append(stream, vc2.frames[i]...)
+		}
+	}
+
+	var want []string
+	for i := 0; i < len(vc1.frames) || i < len(vc2.frames); i++ {
+		for _, c := range []*testChannel{vc1, vc2} {
+			for j, p := range c.packets {
+				end := c.starts[j] + len(p)
+				if (end-1)/zoneLen != i {
+					continue
+				}
+				if c == vc1 && end > lost*zoneLen && c.starts[j] < (lost+1)*zoneLen {
+					continue
+				}
+				want = append(want, fmt.Sprintf("%d/%d", c.vcid, j))
+			}
+		}
+	}
+
+	var (
+		got []string
+		d   = NewFramedDecoder(bytes.NewReader(stream), FrameCADU, nil)
+	)
+	for {
+		p, err := d.Decode(true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		vcid, index := p.Apid()>>8, int(p.Sequence())
+		c := vc1
+		if vcid == vc2.vcid {
+			c = vc2
+		}
+		if !bytes.Equal(p.Data, c.packets[index][CCSDSHeaderLen+ESAHeaderLen:]) {
+			t.Errorf("%d/%d: data mismatched", vcid, index)
+		}
+		got = append(got, fmt.Sprintf("%d/%d", vcid, index))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("packets mismatched: want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("packets mismatched: want %v, got %v", want, got)
+		}
+	}
+}
+
+// testChannel holds the packets of a virtual channel and the CADUs carrying
+// them. The apid of the packets is made of the vcid and their sequence
+// counter is their index.
+type testChannel struct {
+	vcid    uint16
+	packets [][]byte
+	starts  []int
+	frames  [][]byte
+}
+
+func newTestChannel(vcid uint16, counter uint32, count int) *testChannel {
+	c := testChannel{vcid: vcid}
+
+	var zone []byte
+	for i := 0; i < count; i++ {
+		p := testPacket(0, true, 10+(i*397)%1500)
+		p.Pid |= vcid << 8
+		p.Fragment = 3<<14 | uint16(i)
+
+		buf, _ := p.Marshal()
+		c.starts = append(c.starts, len(zone))
+		c.packets = append(c.packets, buf[PTHHeaderLen:])
+		zone = append(zone, buf[PTHHeaderLen:]...)
+	}
+	// the end of the last frame is filled with an idle packet
+	if n := zoneLen - len(zone)%zoneLen; n < zoneLen {
+		if n <= CCSDSHeaderLen {
+			n += zoneLen
+		}
+		idle := make([]byte, n)
+		binary.BigEndian.PutUint16(idle, idlePid)
+		binary.BigEndian.PutUint16(idle[4:], uint16(n-CCSDSHeaderLen-1))
+		zone = append(zone, idle...)
+	}
+	for i := 0; i < len(zone)/zoneLen; i++ {
+		first := noFirst
+		for _, s := range c.starts {
+			if s >= i*zoneLen && s < (i+1)*zoneLen {
+				first = s - i*zoneLen
+				break
+			}
+		}
+		frame := makeFrame(uint8(vcid), counter, first)
+		copy(frame[CADULen-zoneLen:], zone[i*zoneLen:])
+		c.frames = append(c.frames, frame)
+		counter = (counter + 1) & 0xFFFFFF
+	}
+	return &c
+}
+
+func makeFrame(vcid uint8, counter uint32, first int) []byte {
+	frame := make([]byte, CADULen)
+	binary.BigEndian.PutUint32(frame, asm)
+	binary.BigEndian.PutUint32(frame[ASMLen+1:], uint32(vcid&0x3F)<<24|counter&0xFFFFFF)
+	binary.BigEndian.PutUint16(frame[ASMLen+VCDUHeaderLen:], uint16(first))
+	return frame
+}
+
+func idleFrame() []byte {
+	return makeFrame(idleVC, 0, idleFHP)
+}
@@ -0,0 +1,98 @@
+package pathtm
+
+const (
+	seqModulo = 1 << 14
+	seqWindow = seqModulo / 2
+)
+
+// SeqKind classifies a packet according to the sequence counter of the
+// previous packet of its apid.
+type SeqKind uint8
+
+const (
+	SeqInOrder SeqKind = iota
+	SeqGap
+	SeqDuplicate
+	SeqOutOfOrder
+	SeqReset
+)
+
+func (k SeqKind) String() string {
+	switch k {
+	default:
+		return "***"
+	case SeqInOrder:
+		return "in order"
+	case SeqGap:
+		return "gap"
+	case SeqDuplicate:
+		return "duplicate"
+	case SeqOutOfOrder:
+		return "out of order"
+	case SeqReset:
+		return "reset"
+	}
+}
+
+// Continuity describes how a packet follows the last packet seen for its apid.
+// Missing is only set for SeqGap and gives the number of packets lost between
+// Last and the packet.
+type Continuity struct {
+	Kind    SeqKind
+	Missing int
+	Last    Packet
+}
+
+// GapTracker classifies the packets of each apid as they come. The sequence
+// counters are compared modulo 2^14 so that gaps across the wrap of the
+// counter are detected. When the counters alone are ambiguous, the ESA time of
+// the packets decides: a packet with a counter behind the last one is out of
+// order if it is older and is a counter reset if it is more recent.
+type GapTracker struct {
+	seen map[uint16]Packet
+}
+
+func NewGapTracker() *GapTracker {
+	return &GapTracker{
+		seen: make(map[uint16]Packet),
+	}
+}
+
+// Track classifies p. The first packet of an apid is always in order.
+// Duplicated and out of order packets do not replace the last packet of their
+// apid.
+func (g *GapTracker) Track(p Packet) Continuity {
+	last, ok := g.seen[p.Apid()]
+	if !ok {
+		g.seen[p.Apid()] = p
+		return Continuity{Kind: SeqInOrder}
+	}
+	c := Continuity{Last: last}
+
+	var (
+		diff = int(p.Sequence()-last.Sequence()) & (seqModulo - 1)
+		cmp  = compareTime(p.Timestamp(), last.Timestamp())
+	)
+	switch {
+	case diff == 1:
+		c.Kind = SeqInOrder
+	case diff == 0 && cmp <= 0:
+		c.Kind = SeqDuplicate
+	case diff == 0:
+		c.Kind = SeqReset
+	case diff < seqWindow && cmp >= 0:
+		c.Kind, c.Missing = SeqGap, diff-1
+	case diff < seqWindow:
+		c.Kind = SeqOutOfOrder
+	case cmp > 0:
+		c.Kind = SeqReset
+	default:
+		c.Kind = SeqOutOfOrder
+	}
+	switch c.Kind {
+	case SeqDuplicate, SeqOutOfOrder:
+	default:
+		g.seen[p.Apid()] = p
+	}
+	return c
+}
@@ -0,0 +1,45 @@
+package pathtm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGapTracker(t *testing.T) {
+	data := []struct {
+		Apid     uint16
+		Sequence uint16
+		Time     int
+		Kind     SeqKind
+		Missing  int
+	}{
+		{Apid: 1, Sequence: 10, Time: 0, Kind: SeqInOrder},
+		{Apid: 1, Sequence: 11, Time: 1, Kind: SeqInOrder},
+		{Apid: 1, Sequence: 11, Time: 1, Kind: SeqDuplicate},
+		{Apid: 1, Sequence: 14, Time: 2, Kind: SeqGap, Missing: 2},
+		{Apid: 1, Sequence: 12, Time: 1, Kind: SeqOutOfOrder},
+		{Apid: 2, Sequence: 16383, Time: 2, Kind: SeqInOrder},
+		{Apid: 2, Sequence: 5, Time: 3, Kind: SeqGap, Missing: 5},
+		{Apid: 2, Sequence: 6, Time: 4, Kind: SeqInOrder},
+		{Apid: 2, Sequence: 16380, Time: 2, Kind: SeqOutOfOrder},
+		{Apid: 1, Sequence: 15, Time: 3, Kind: SeqInOrder},
+		{Apid: 1, Sequence: 0, Time: 4, Kind: SeqReset},
+		{Apid: 1, Sequence: 0, Time: 5, Kind: SeqReset},
+		{Apid: 1, Sequence: 1, Time: 6, Kind: SeqInOrder},
+	}
+	var (
+		tracker = NewGapTracker()
+		start   = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+	for i, d := range data {
+		var p Packet
+		p.Pid = d.Apid
+		p.Fragment = 3<<14 | d.Sequence
+		p.ESAHeader.Coarse, p.ESAHeader.Fine = SplitTime(start.Add(time.Duration(d.Time) * time.Second))
+
+		c := tracker.Track(p)
+		if c.Kind != d.Kind || c.Missing != d.Missing {
+			t.Errorf("%d: apid %d, sequence %d: want %s (%d missing), got %s (%d missing)", i+1, d.Apid, d.Sequence, d.Kind, d.Missing, c.Kind, c.Missing)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package gen generates synthetic PTH telemetry, optionally corrupted by
+// controlled faults, to exercise the decoder and the tools built on pathtm.
+//
+// The packets are generated from a seed so that the same seed, start time,
+// sources and faults always give the same stream.
+package gen
+
+import (
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/busoc/pathtm"
+)
+
+// Fault is a corruption injected in the generated stream.
+type Fault uint8
+
+const (
+	NoFault Fault = iota
+	// FaultGap skips some sequence counters before the packet.
+	FaultGap
+	// FaultDuplicate sends again the previous packet of the source.
+	FaultDuplicate
+	// FaultWrap moves the sequence counter of the source a few below its
+	// highest value before the packet. The counter of the next packet of the
+	// source crosses 0 with a gap (e.g. 16383 then 5).
+	FaultWrap
+	// FaultVersion sets an invalid CCSDS version in the packet.
+	FaultVersion
+	// FaultTruncate only writes the first half of the packet.
+	FaultTruncate
+	// FaultJump moves the ESA time of the packet forward by one hour.
+	FaultJump
+	// FaultReset restarts the sequence counter of the source at 0 before the
+	// packet.
+	FaultReset
+)
+
+func (f Fault) String() string {
+	switch f {
+	default:
+		return "***"
+	case NoFault:
+		return "none"
+	case FaultGap:
+		return "gap"
+	case FaultDuplicate:
+		return "duplicate"
+	case FaultWrap:
+		return "wrap"
+	case FaultVersion:
+		return "version"
+	case FaultTruncate:
+		return "truncate"
+	case FaultJump:
+		return "jump"
+	case FaultReset:
+		return "reset"
+	}
+}
+
+const (
+	maxSkip  = 8
+	timeJump = time.Hour
+	seqMask  = 0x3FFF
+	sechFlag = 1 << 11
+	badPid   = 1 << 13
+)
+
+// Source describes the packets of one apid. A packet is generated every
+// Interval with a payload of Size bytes, or between Size and MaxSize bytes
+// when MaxSize is greater than Size. With Segments greater than 1, packets are
+// generated in groups of Segments packets flagged as first, continuation and
+// last. Sequence is the counter of the first packet.
+type Source struct {
+	Apid     uint16
+	Sid      uint32
+	Type     pathtm.ESAPacketType
+	Interval time.Duration
+	Size     int
+	MaxSize  int
+	Segments int
+	Sequence uint16
+}
+
+// Record is a generated packet with the fault injected in it.
+type Record struct {
+	pathtm.Packet
+	Fault Fault
+}
+
+// Bytes gives the bytes of the packet as written by the generator.
+func (r Record) Bytes() ([]byte, error) {
+	buf, err := r.Marshal()
+	if err == nil && r.Fault == FaultTruncate {
+		buf = buf[:len(buf)/2]
+	}
+	return buf, err
+}
+
+type source struct {
+	Source
+
+	when    time.Time
+	seq     uint16
+	segment int
+	last    *pathtm.Packet
+	// set after a FaultWrap: the counter of the next packet crosses 0
+	wrap bool
+}
+
+type fault struct {
+	Fault
+	prob float64
+}
+
+type Generator struct {
+	rand    *rand.Rand
+	sources []*source
+	faults  []fault
+}
+
+// New gives a generator of the packets of the given sources starting at
+// start. Sources without interval generate one packet per second.
+func New(seed int64, start time.Time, ss ...Source) *Generator {
+	g := Generator{
+		rand:    rand.New(rand.NewSource(seed)),
+		sources: make([]*source, len(ss)),
+	}
+	for i, s := range ss {
+		if s.Interval <= 0 {
+			s.Interval = time.Second
+		}
+		g.sources[i] = &source{
+			Source: s,
+			when:   start,
+			seq:    s.Sequence & seqMask,
+		}
+	}
+	return &g
+}
+
+// Inject makes the generator inject f in the packets with the probability
+// prob. When several faults are drawn for one packet, only the first one
+// given to Inject is injected.
+func (g *Generator) Inject(f Fault, prob float64) {
+	if f == NoFault || prob <= 0 {
+		return
+	}
+	g.faults = append(g.faults, fault{Fault: f, prob: prob})
+}
+
+// Next gives the next packet in time of all the sources.
+func (g *Generator) Next() Record {
+	if len(g.sources) == 0 {
+		return Record{}
+	}
+	s := g.sources[0]
+	for _, o := range g.sources[1:] {
+		if o.when.Before(s.when) {
+			s = o
+		}
+	}
+	f := g.draw()
+	if f == FaultDuplicate && s.last != nil {
+		return Record{Packet: *s.last, Fault: f}
+	}
+	if s.wrap && f != FaultWrap {
+		s.seq, s.wrap = uint16(1+g.rand.Intn(maxSkip)), false
+	}
+	switch f {
+	case FaultDuplicate:
+		f = NoFault
+	case FaultGap:
+		s.seq = (s.seq + uint16(1+g.rand.Intn(maxSkip))) & seqMask
+	case FaultWrap:
+		s.seq, s.wrap = seqMask-uint16(g.rand.Intn(maxSkip)), true
+	case FaultReset:
+		s.seq = 0
+	}
+	p := g.generate(s)
+	last := p
+	s.last = &last
+
+	switch f {
+	case FaultVersion:
+		p.Pid |= badPid
+	case FaultJump:
+		p.ESAHeader.Coarse, p.ESAHeader.Fine = pathtm.SplitTime(s.when.Add(timeJump))
+	}
+	s.when = s.when.Add(s.Interval)
+	return Record{Packet: p, Fault: f}
+}
+
+// Generate writes the next n packets to w.
+func (g *Generator) Generate(w io.Writer, n int) error {
+	for i := 0; i < n; i++ {
+		buf, err := g.Next().Bytes()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) generate(s *source) pathtm.Packet {
+	size := s.Size
+	if s.MaxSize > s.Size {
+		size += g.rand.Intn(s.MaxSize - s.Size + 1)
+	}
+	if size <= 0 {
+		size = 1
+	}
+	var p pathtm.Packet
+	p.Data = make([]byte, size)
+	g.rand.Read(p.Data)
+
+	p.Pid = sechFlag | (s.Apid & 0x7FF)
+	p.Fragment = uint16(s.segmentation())<<14 | s.seq
+	p.Length = uint16(pathtm.ESAHeaderLen + size - 1)
+
+	p.ESAHeader.Coarse, p.ESAHeader.Fine = pathtm.SplitTime(s.when)
+	p.ESAHeader.Sid = s.Sid
+	p.ESAHeader.Info = uint8(s.Type & 0xF)
+
+	p.PTHHeader.Coarse, p.PTHHeader.Fine = p.ESAHeader.Coarse, p.ESAHeader.Fine
+	p.PTHHeader.Size = uint32(pathtm.PTHHeaderLen - 4 + pathtm.CCSDSHeaderLen + int(p.Len()))
+
+	s.seq = (s.seq + 1) & seqMask
+	return p
+}
+
+func (g *Generator) draw() Fault {
+	for _, f := range g.faults {
+		if g.rand.Float64() < f.prob {
+			return f.Fault
+		}
+	}
+	return NoFault
+}
+
+// segmentation gives the segmentation flags of the next packet of s.
+func (s *source) segmentation() pathtm.CCSDSSegment {
+	if s.Segments <= 1 {
+		return 3
+	}
+	defer func() {
+		s.segment = (s.segment + 1) % s.Segments
+	}()
+	switch s.segment {
+	case 0:
+		return 1
+	case s.Segments - 1:
+		return 2
+	default:
+		return 0
+	}
+}
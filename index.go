@@ -0,0 +1,96 @@
+package pathtm
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Entry locates a packet in an archive file. Offset is the position of the
+// first byte of the PTH header of the packet in File and Length is the number
+// of bytes of the packet including its PTH header.
+type Entry struct {
+	File     string
+	Offset   int64
+	Length   int
+	Apid     uint16
+	Sid      uint32
+	Sequence uint16
+	ESATime  time.Time
+	PTHTime  time.Time
+	Hash     uint64
+}
+
+// IndexedReader decodes the packets located by a list of entries, usually
+// given by an index of an archive, without reading the rest of the files.
+type IndexedReader struct {
+	filter  Filter
+	entries []Entry
+
+	file   *os.File
+	buffer []byte
+}
+
+func NewIndexedReader(es []Entry, filter Filter) *IndexedReader {
+	if filter == nil {
+		filter = Any()
+	}
+	return &IndexedReader{
+		filter:  filter,
+		entries: es,
+		buffer:  make([]byte, BufferSize),
+	}
+}
+
+func (r *IndexedReader) Decode(data bool) (p Packet, err error) {
+	for len(r.entries) > 0 {
+		e := r.entries[0]
+		r.entries = r.entries[1:]
+
+		var keep bool
+		if p, err = r.decodeEntry(e, data); err != nil {
+			return
+		}
+		if keep, err = r.filter(p.PTHHeader, p.CCSDSHeader, p.ESAHeader); keep || err != nil {
+			return
+		}
+	}
+	return p, io.EOF
+}
+
+func (r *IndexedReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *IndexedReader) decodeEntry(e Entry, data bool) (Packet, error) {
+	if r.file == nil || r.file.Name() != e.File {
+		if err := r.Close(); err != nil {
+			return Packet{}, err
+		}
+		f, err := os.Open(e.File)
+		if err != nil {
+			return Packet{}, err
+		}
+		r.file = f
+	}
+	if len(r.buffer) < e.Length {
+		r.buffer = make([]byte, e.Length)
+	}
+	body := r.buffer[:e.Length]
+	if _, err := r.file.ReadAt(body, e.Offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Packet{}, err
+	}
+	p, err := decodePacket(body, data)
+	if de, ok := err.(*DecodeError); ok {
+		de.File, de.Offset = e.File, e.Offset
+	}
+	return p, err
+}
@@ -0,0 +1,185 @@
+package pathtm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// MappedFile gives random access to the packets of a PTH file mapped in
+// memory. The packets given by a MappedFile refer to the mapping and are only
+// valid until the file is closed.
+//
+// The time searches expect the packets of the file to be ordered by the time
+// being searched, as they are in the files of the archive.
+type MappedFile struct {
+	name    string
+	base    int64
+	data    []byte
+	offsets []int
+	unmap   func() error
+
+	// error that stopped the location of the packets
+	err error
+}
+
+// OpenMapped maps file in memory and locates each of its packets. As for a
+// Decoder, the packets found before a corrupted or truncated packet are
+// available and the error is given by Err.
+func OpenMapped(file string) (*MappedFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	i, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mapFile(f, int(i.Size()))
+	if err != nil {
+		return nil, err
+	}
+	m := MappedFile{
+		name:  file,
+		data:  data,
+		unmap: unmap,
+	}
+	m.walk()
+	return &m, nil
+}
+
+// walk builds the table of the offsets of the packets from the size given in
+// their PTH header. It stops at the first packet whose size is invalid and
+// only keeps the bytes of the packets before it.
+func (m *MappedFile) walk() {
+	for offset := 0; offset < len(m.data); {
+		var err error
+		if len(m.data)-offset < pthLenSize {
+			err = io.ErrUnexpectedEOF
+		}
+		var size int
+		if err == nil {
+			size = int(binary.LittleEndian.Uint32(m.data[offset:]))
+			switch {
+			case size < PTHHeaderLen-pthLenSize+CCSDSHeaderLen:
+				err = io.ErrShortBuffer
+			case size > PTHHeaderLen-pthLenSize+CCSDSHeaderLen+maxCCSDSLen:
+				err = ErrTooLarge
+			case offset+pthLenSize+size > len(m.data):
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			e := headerError(StagePTH, m.data[offset:], PTHHeaderLen, err).(*DecodeError)
+			e.File, e.Offset, e.Index = m.name, m.base+int64(offset), len(m.offsets)+1
+			m.data, m.err = m.data[:offset:offset], e
+			return
+		}
+		m.offsets = append(m.offsets, offset)
+		offset += pthLenSize + size
+	}
+}
+
+// Err gives the error that prevented the packets of the file to be located
+// after the last one given by Len. It is nil if the whole file has been read.
+func (m *MappedFile) Err() error {
+	return m.err
+}
+
+// Len gives the number of packets of the file.
+func (m *MappedFile) Len() int {
+	return len(m.offsets)
+}
+
+// Offset gives the position in the file of the first byte of the i-th packet.
+func (m *MappedFile) Offset(i int) int64 {
+	return m.base + int64(m.offsets[i])
+}
+
+// Bytes gives the bytes of the i-th packet, including its PTH header.
+func (m *MappedFile) Bytes(i int) []byte {
+	end := len(m.data)
+	if i+1 < len(m.offsets) {
+		end = m.offsets[i+1]
+	}
+	return m.data[m.offsets[i]:end:end]
+}
+
+// PacketAt decodes the i-th packet. Its Data refers to the mapped file.
+func (m *MappedFile) PacketAt(i int) (Packet, error) {
+	p, err := viewPacket(m.Bytes(i))
+	if e, ok := err.(*DecodeError); ok {
+		e.File, e.Offset, e.Index = m.name, m.Offset(i), i+1
+	}
+	return p, err
+}
+
+// Search gives the index of the first packet with an ESA time not before t,
+// or Len if there is none.
+func (m *MappedFile) Search(t time.Time) int {
+	return sort.Search(m.Len(), func(i int) bool {
+		e, err := decodeESA(m.Bytes(i)[PTHHeaderLen+CCSDSHeaderLen:])
+		return err == nil && !e.Timestamp().Before(t)
+	})
+}
+
+// SearchReceived gives the index of the first packet with a PTH time not
+// before t, or Len if there is none.
+func (m *MappedFile) SearchReceived(t time.Time) int {
+	return sort.Search(m.Len(), func(i int) bool {
+		h, err := decodePTH(m.Bytes(i))
+		return err == nil && !h.Timestamp().Before(t)
+	})
+}
+
+// Slice gives the packets from i to j (excluded) without copying them. The
+// returned MappedFile shares the mapping of m and closing it has no effect.
+func (m *MappedFile) Slice(i, j int) *MappedFile {
+	if i >= j {
+		return &MappedFile{name: m.name, unmap: func() error { return nil }}
+	}
+	var (
+		start = m.offsets[i]
+		end   = len(m.data)
+	)
+	if j < len(m.offsets) {
+		end = m.offsets[j]
+	}
+	s := MappedFile{
+		name:    m.name,
+		base:    m.base + int64(start),
+		data:    m.data[start:end:end],
+		offsets: make([]int, j-i),
+		unmap:   func() error { return nil },
+	}
+	for k := range s.offsets {
+		s.offsets[k] = m.offsets[i+k] - start
+	}
+	return &s
+}
+
+// Between gives the packets of m with an ESA time between start (included)
+// and end (excluded).
+func (m *MappedFile) Between(start, end time.Time) *MappedFile {
+	return m.Slice(m.Search(start), m.Search(end))
+}
+
+// Reader gives a reader of the bytes of the packets of m that can be given to
+// a Decoder.
+func (m *MappedFile) Reader() io.Reader {
+	return bytes.NewReader(m.data)
+}
+
+func (m *MappedFile) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.data, m.offsets, m.unmap = nil, nil, nil
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package pathtm
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the whole file in memory on systems without mmap.
+func mapFile(f *os.File, size int) ([]byte, func() error, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, nil, err
+	}
+	return buf, func() error { return nil }, nil
+}
@@ -0,0 +1,216 @@
+package pathtm
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Opener opens the file to be decoded by a ParallelDecoder.
+type Opener func(string) (io.ReadCloser, error)
+
+func openFile(file string) (io.ReadCloser, error) {
+	return os.Open(file)
+}
+
+// chunkSize is the number of packets given at once by a worker and chunkCount
+// the number of chunks a worker can decode ahead of Decode.
+const (
+	chunkSize  = 256
+	chunkCount = 4
+)
+
+type batch struct {
+	packets []Packet
+	err     error
+}
+
+// ParallelDecoder decodes several files concurrently and gives their packets
+// in the order of the files, as a Decoder reading the files one after the
+// other would do. Each file is decoded by its own Decoder so that packets can
+// not span two files.
+//
+// The workers start with the first call to Decode. No more than workers files
+// are decoded at once and each worker only decodes chunkCount*chunkSize
+// packets ahead of Decode.
+type ParallelDecoder struct {
+	open    Opener
+	filter  Filter
+	data    bool
+	workers int
+	files   []string
+
+	resync *resync
+
+	start   sync.Once
+	once    sync.Once
+	batches chan chan batch
+	stop    chan struct{}
+
+	current chan batch
+	packets []Packet
+	err     error
+}
+
+// NewParallelDecoder gives a decoder of files using the given number of
+// workers. data tells whether the payload of the packets are decoded. A nil
+// opener opens the files with os.Open.
+func NewParallelDecoder(files []string, workers int, open Opener, filter Filter, data bool) *ParallelDecoder {
+	if workers <= 0 {
+		workers = 1
+	}
+	if open == nil {
+		open = openFile
+	}
+	if filter == nil {
+		filter = Any()
+	}
+	p := ParallelDecoder{
+		open:    open,
+		filter:  filter,
+		data:    data,
+		workers: workers,
+		files:   files,
+		batches: make(chan chan batch, workers),
+		stop:    make(chan struct{}),
+	}
+	return &p
+}
+
+// Resync enables the resynchronisation of the decoders of each file. fn can
+// be called from several goroutines at once. It has no effect once Decode has
+// been called.
+func (p *ParallelDecoder) Resync(first, last uint16, fn func(SkipError)) {
+	if fn == nil {
+		fn = func(_ SkipError) {}
+	}
+	p.resync = &resync{
+		first: first,
+		last:  last,
+		skip:  fn,
+	}
+}
+
+// Decode gives the next packet. The payload of the packets is only given when
+// data is set here and when creating the decoder.
+func (p *ParallelDecoder) Decode(data bool) (Packet, error) {
+	p.start.Do(func() {
+		go p.run(p.files)
+	})
+	for len(p.packets) == 0 {
+		if p.err != nil {
+			// as for a Decoder, the errors of a file are only given once
+			// and the decoding goes on with the next packets.
+			err := p.err
+			if err != io.EOF {
+				p.err = nil
+			}
+			return Packet{}, err
+		}
+		if p.current == nil {
+			c, ok := <-p.batches
+			if !ok {
+				p.err = io.EOF
+				continue
+			}
+			p.current = c
+		}
+		b, ok := <-p.current
+		if !ok {
+			p.current = nil
+			continue
+		}
+		p.packets, p.err = b.packets, b.err
+	}
+	k := p.packets[0]
+	p.packets = p.packets[1:]
+	if !data {
+		k.Data = nil
+	}
+	return k, nil
+}
+
+// Close stops the decoding of the files not yet decoded.
+func (p *ParallelDecoder) Close() error {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	return nil
+}
+
+func (p *ParallelDecoder) run(files []string) {
+	defer close(p.batches)
+
+	sema := make(chan struct{}, p.workers)
+	for _, f := range files {
+		c := make(chan batch, chunkCount)
+		select {
+		case p.batches <- c:
+		case <-p.stop:
+			return
+		}
+		select {
+		case sema <- struct{}{}:
+		case <-p.stop:
+			close(c)
+			return
+		}
+		go func(file string) {
+			defer func() { <-sema }()
+			defer close(c)
+			p.decodeFile(file, c)
+		}(f)
+	}
+}
+
+// decodeFile sends the packets of file by chunks to c. A chunk ends with each
+// error given by the decoder of the file.
+func (p *ParallelDecoder) decodeFile(file string, c chan<- batch) {
+	var b batch
+	send := func() bool {
+		select {
+		case c <- b:
+			b = batch{}
+			return true
+		case <-p.stop:
+			return false
+		}
+	}
+
+	r, err := p.open(file)
+	if err != nil {
+		b.err = err
+		send()
+		return
+	}
+	defer r.Close()
+
+	d := NewDecoder(r, p.filter)
+	if p.resync != nil {
+		d.Resync(p.resync.first, p.resync.last, p.resync.skip)
+	}
+	for {
+		k, err := d.Decode(p.data)
+		if err == io.EOF {
+			if len(b.packets) > 0 {
+				send()
+			}
+			return
+		}
+		if err != nil {
+			// only the errors of a packet let the decoder go on with the
+			// rest of the file.
+			b.err = err
+			if _, ok := err.(*DecodeError); !send() || !ok {
+				return
+			}
+			continue
+		}
+		if b.packets == nil {
+			b.packets = make([]Packet, 0, chunkSize)
+		}
+		if b.packets = append(b.packets, k); len(b.packets) >= chunkSize && !send() {
+			return
+		}
+	}
+}
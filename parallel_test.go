@@ -0,0 +1,94 @@
+package pathtm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParallelDecoder(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		files []string
+	)
+	for i := 0; i < 6; i++ {
+		buf := testStream(t, 700, 16)
+		switch i {
+		case 1:
+			// invalid version in the 3rd packet
+			buf[2*42+PTHHeaderLen] |= 0x20
+		case 3:
+			// truncated last packet
+			buf = buf[:len(buf)-5]
+		case 4:
+			// invalid size of the PTH header in the middle of the file
+			buf[300*42] = 1
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d.dat", i))
+		if err := os.WriteFile(file, buf, 0644); err != nil {
+			t.Fatalf("fail to write %s: %s", file, err)
+		}
+		files = append(files, file)
+	}
+
+	var (
+		want []string
+		all  multiReader
+	)
+	for _, f := range files {
+		r, err := os.Open(f)
+		if err != nil {
+			t.Fatalf("fail to open %s: %s", f, err)
+		}
+		want = append(want, decodeAll(NewDecoder(r, nil), 0)...)
+		r.Close()
+
+		buf, _ := os.ReadFile(f)
+		all.names, all.files = append(all.names, f), append(all.files, buf)
+	}
+	// a decoder reading all the files one after the other goes on with the
+	// next file after a framing error.
+	got := decodeAll(NewDecoder(&all, nil), len(want)+1)
+	compareResults(t, "decoder", want, got)
+
+	for _, workers := range []int{1, 2, 4} {
+		d := NewParallelDecoder(files, workers, nil, nil, true)
+		got := decodeAll(d, len(want)+1)
+		d.Close()
+		compareResults(t, fmt.Sprintf("%d workers", workers), want, got)
+	}
+}
+
+func compareResults(t *testing.T, name string, want, got []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: length mismatched: want %d, got %d", name, len(want), len(got))
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: %d: want %s, got %s", name, i, want[i], got[i])
+			return
+		}
+	}
+}
+
+// decodeAll describes the packets and the errors given by d until io.EOF. With
+// a limit greater than 0, it stops after limit results.
+func decodeAll(d interface{ Decode(bool) (Packet, error) }, limit int) []string {
+	var rs []string
+	for limit <= 0 || len(rs) < limit {
+		p, err := d.Decode(true)
+		switch {
+		case err == io.EOF:
+			return rs
+		case err != nil:
+			rs = append(rs, err.Error())
+		default:
+			rs = append(rs, fmt.Sprintf("%d/%d/%d", p.Apid(), p.Sequence(), len(p.Data)))
+		}
+	}
+	return rs
+}
@@ -28,6 +28,8 @@ type Packet struct {
 	ESAHeader
 	Data []byte
 	Sum  uint32
+
+	crc crcState
 }
 
 func (p Packet) Timestamp() time.Time {
@@ -42,18 +44,38 @@ func (p Packet) Missing(other Packet) int {
 }
 
 func (p Packet) Marshal() ([]byte, error) {
+	return p.MarshalAppend(nil)
+}
+
+// MarshalAppend appends the bytes of the packet to dst and gives the extended
+// slice. No memory is allocated when dst has enough capacity.
+func (p Packet) MarshalAppend(dst []byte) ([]byte, error) {
 	if len(p.Data) == 0 {
-		return nil, ErrEmpty
+		return dst, ErrEmpty
 	}
-	var offset int
-	buf := make([]byte, PTHHeaderLen+CCSDSHeaderLen+int(p.Len()))
-	offset += copy(buf[offset:], encodePTH(p.PTHHeader))
-	offset += copy(buf[offset:], encodeCCSDS(p.CCSDSHeader))
+	var (
+		offset = len(dst)
+		size   = PTHHeaderLen + CCSDSHeaderLen + p.bodyLen()
+	)
+	dst = append(dst, make([]byte, size)...)
+	buf := dst[offset:]
+
+	var (
+		pth   [PTHHeaderLen]byte
+		ccsds [CCSDSHeaderLen]byte
+		esa   [ESAHeaderLen]byte
+	)
+	encodePTH(pth[:], p.PTHHeader)
+	encodeCCSDS(ccsds[:], p.CCSDSHeader)
+
+	offset = copy(buf, pth[:])
+	offset += copy(buf[offset:], ccsds[:])
 	if set := (p.CCSDSHeader.Pid >> 11) & 0x1; set != 0 {
-		offset += copy(buf[offset:], encodeESA(p.ESAHeader))
+		encodeESA(esa[:], p.ESAHeader)
+		offset += copy(buf[offset:], esa[:])
 	}
-	offset += copy(buf[offset:], p.Data)
-	return buf, nil
+	copy(buf[offset:], p.Data)
+	return dst, nil
 }
 
 type ESAPacketType uint8
@@ -177,6 +199,12 @@ func (c CCSDSHeader) Len() uint16 {
 	return c.Length + 1
 }
 
+// bodyLen gives the number of bytes following the CCSDS header. Unlike Len, it
+// does not overflow when Length is 0xFFFF.
+func (c CCSDSHeader) bodyLen() int {
+	return int(c.Length) + 1
+}
+
 func (c CCSDSHeader) Apid() uint16 {
 	return c.Pid & 0x07FF
 }
@@ -204,6 +232,10 @@ func (e ESAHeader) PacketType() ESAPacketType {
 	return ESAPacketType(e.Info & 0xF)
 }
 
+func DecodePTH(body []byte) (PTHHeader, error) {
+	return decodePTH(body)
+}
+
 func decodePTH(body []byte) (PTHHeader, error) {
 	var h PTHHeader
 	if len(body) < PTHHeaderLen {
@@ -217,15 +249,11 @@ func decodePTH(body []byte) (PTHHeader, error) {
 	return h, nil
 }
 
-func encodePTH(h PTHHeader) []byte {
-	buf := make([]byte, PTHHeaderLen)
-
+func encodePTH(buf []byte, h PTHHeader) {
 	binary.LittleEndian.PutUint32(buf, h.Size)
 	buf[4] = byte(h.Type)
 	binary.BigEndian.PutUint32(buf[5:], h.Coarse)
 	buf[9] = byte(h.Fine)
-
-	return buf
 }
 
 func DecodeCCSDS(body []byte) (CCSDSHeader, error) {
@@ -248,14 +276,10 @@ func decodeCCSDS(body []byte) (CCSDSHeader, error) {
 	return h, nil
 }
 
-func encodeCCSDS(c CCSDSHeader) []byte {
-	buf := make([]byte, CCSDSHeaderLen)
-
+func encodeCCSDS(buf []byte, c CCSDSHeader) {
 	binary.BigEndian.PutUint16(buf, c.Pid)
 	binary.BigEndian.PutUint16(buf[2:], c.Fragment)
 	binary.BigEndian.PutUint16(buf[4:], c.Length)
-
-	return buf
 }
 
 func DecodeESA(body []byte) (ESAHeader, error) {
@@ -276,13 +300,9 @@ func decodeESA(body []byte) (ESAHeader, error) {
 	return h, nil
 }
 
-func encodeESA(e ESAHeader) []byte {
-	buf := make([]byte, ESAHeaderLen)
-
+func encodeESA(buf []byte, e ESAHeader) {
 	binary.BigEndian.PutUint32(buf, e.Coarse)
 	buf[4] = byte(e.Fine)
 	buf[5] = byte(e.Info)
 	binary.BigEndian.PutUint32(buf[6:], e.Sid)
-
-	return buf
 }
@@ -0,0 +1,217 @@
+package pathtm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPTHHeader(t *testing.T) {
+	data := []PTHHeader{
+		{},
+		{Size: 42, Type: 1, Coarse: 1234567890, Fine: 128},
+		{Size: 0xFFFFFFFF, Type: 0xFF, Coarse: 0xFFFFFFFF, Fine: 0xFF},
+	}
+	for _, d := range data {
+		var buf [PTHHeaderLen]byte
+		encodePTH(buf[:], d)
+		got, err := DecodePTH(buf[:])
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", d, err)
+			continue
+		}
+		if got != d {
+			t.Errorf("pth header mismatched: want %+v, got %+v", d, got)
+		}
+	}
+	if _, err := DecodePTH(make([]byte, PTHHeaderLen-1)); err != io.ErrShortBuffer {
+		t.Errorf("short buffer: unexpected error: %v", err)
+	}
+}
+
+func TestCCSDSHeader(t *testing.T) {
+	data := []CCSDSHeader{
+		{},
+		{Pid: 0x0800 | 1234, Fragment: 0xC000 | 42, Length: 100},
+		{Pid: 0x1FFF, Fragment: 0xFFFF, Length: 0xFFFF},
+	}
+	for _, d := range data {
+		var buf [CCSDSHeaderLen]byte
+		encodeCCSDS(buf[:], d)
+		got, err := DecodeCCSDS(buf[:])
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", d, err)
+			continue
+		}
+		if got != d {
+			t.Errorf("ccsds header mismatched: want %+v, got %+v", d, got)
+		}
+	}
+	var buf [CCSDSHeaderLen]byte
+	encodeCCSDS(buf[:], CCSDSHeader{Pid: 0x2000})
+	if _, err := DecodeCCSDS(buf[:]); err != ErrVersion {
+		t.Errorf("bad version: unexpected error: %v", err)
+	}
+	if _, err := DecodeCCSDS(buf[:CCSDSHeaderLen-1]); err != io.ErrShortBuffer {
+		t.Errorf("short buffer: unexpected error: %v", err)
+	}
+}
+
+func TestESAHeader(t *testing.T) {
+	data := []ESAHeader{
+		{},
+		{Coarse: 1234567890, Fine: 64, Sid: 42, Info: uint8(ScienceData)},
+		{Coarse: 0xFFFFFFFF, Fine: 0xFF, Sid: 0xFFFFFFFF, Info: 0xFF},
+	}
+	for _, d := range data {
+		var buf [ESAHeaderLen]byte
+		encodeESA(buf[:], d)
+		got, err := DecodeESA(buf[:])
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", d, err)
+			continue
+		}
+		if got != d {
+			t.Errorf("esa header mismatched: want %+v, got %+v", d, got)
+		}
+	}
+	if _, err := DecodeESA(make([]byte, ESAHeaderLen-1)); err != io.ErrShortBuffer {
+		t.Errorf("short buffer: unexpected error: %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	data := []struct {
+		Name string
+		Packet
+	}{
+		{Name: "esa", Packet: testPacket(1234, true, 32)},
+		{Name: "no-esa", Packet: testPacket(1234, false, 32)},
+		{Name: "one-byte", Packet: testPacket(42, false, 1)},
+		{Name: "largest", Packet: testPacket(42, true, maxCCSDSLen-ESAHeaderLen)},
+	}
+	for _, d := range data {
+		buf, err := d.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if want := PTHHeaderLen + CCSDSHeaderLen + int(d.Length) + 1; len(buf) != want {
+			t.Errorf("%s: length mismatched: want %d, got %d", d.Name, want, len(buf))
+			continue
+		}
+		got, err := DecodePacket(buf, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if got.PTHHeader != d.PTHHeader || got.CCSDSHeader != d.CCSDSHeader || got.ESAHeader != d.ESAHeader {
+			t.Errorf("%s: headers mismatched: want %+v, got %+v", d.Name, d.Packet, got)
+		}
+		if !bytes.Equal(got.Data, d.Data) {
+			t.Errorf("%s: data mismatched", d.Name)
+		}
+	}
+	if _, err := (Packet{}).Marshal(); err != ErrEmpty {
+		t.Errorf("empty packet: unexpected error: %v", err)
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	buf, err := testPacket(1234, true, 32).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	short := testPacket(1234, false, 4)
+	short.Pid |= 1 << 11
+	bad, _ := short.Marshal()
+
+	data := []struct {
+		Name  string
+		Body  []byte
+		Stage Stage
+		Err   error
+	}{
+		{Name: "pth", Body: buf[:PTHHeaderLen-1], Stage: StagePTH, Err: io.ErrShortBuffer},
+		{Name: "ccsds", Body: buf[:PTHHeaderLen+CCSDSHeaderLen-1], Stage: StageCCSDS, Err: io.ErrShortBuffer},
+		{Name: "esa", Body: buf[:PTHHeaderLen+CCSDSHeaderLen+ESAHeaderLen-1], Stage: StageESA, Err: io.ErrShortBuffer},
+		{Name: "data", Body: buf[:len(buf)-1], Stage: StageData, Err: io.ErrUnexpectedEOF},
+		{Name: "length", Body: bad, Stage: StageCCSDS, Err: ErrLength},
+	}
+	for _, d := range data {
+		_, err := DecodePacket(d.Body, true)
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected DecodeError, got %v", d.Name, err)
+			continue
+		}
+		if e.Stage != d.Stage || !errors.Is(err, d.Err) {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+		}
+	}
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, p := range []Packet{testPacket(1234, true, 32), testPacket(42, false, 1)} {
+		buf, _ := p.Marshal()
+		f.Add(buf)
+	}
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		p, err := DecodePacket(body, true)
+		if err != nil {
+			return
+		}
+		buf, err := p.Marshal()
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(buf, body[:len(buf)]) {
+			t.Errorf("packet not encoded as decoded")
+		}
+	})
+}
+
+func FuzzDecodeCCSDS(f *testing.F) {
+	f.Add([]byte{0x0C, 0xD2, 0xC0, 0x2A, 0x00, 0x64})
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		c, err := DecodeCCSDS(body)
+		if err != nil {
+			return
+		}
+		var buf [CCSDSHeaderLen]byte
+		encodeCCSDS(buf[:], c)
+		if !bytes.Equal(buf[:], body[:CCSDSHeaderLen]) {
+			t.Errorf("ccsds header not encoded as decoded")
+		}
+	})
+}
+
+// testPacket gives a packet of apid with size bytes of data, with or without
+// ESA header.
+func testPacket(apid uint16, esa bool, size int) Packet {
+	p := Packet{
+		Data: make([]byte, size),
+	}
+	for i := range p.Data {
+		p.Data[i] = byte(i)
+	}
+	p.Pid = apid & 0x7FF
+	p.Fragment = 3<<14 | 42
+	p.Length = uint16(size - 1)
+	if esa {
+		p.Pid |= 1 << 11
+		p.Length += ESAHeaderLen
+		p.ESAHeader = ESAHeader{Coarse: 1234567890, Fine: 128, Sid: 7, Info: uint8(ScienceData)}
+	}
+	p.PTHHeader = PTHHeader{
+		Size:   uint32(PTHHeaderLen - pthLenSize + CCSDSHeaderLen + int(p.Length) + 1),
+		Type:   1,
+		Coarse: 1234567890,
+		Fine:   64,
+	}
+	return p
+}
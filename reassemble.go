@@ -0,0 +1,157 @@
+package pathtm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrOrphan     = errors.New("orphaned segment")
+	ErrIncomplete = errors.New("incomplete segment group")
+)
+
+const (
+	segContinuation CCSDSSegment = iota
+	segFirst
+	segLast
+	segUnsegmented
+)
+
+// Unit is a complete application data unit rebuilt from one or multiple
+// segmented packets. Its Packet holds the headers of the first segment and
+// the user data of all the segments. The headers are kept as they are: the
+// Length of the CCSDS header and the Size of the PTH header are the ones of the
+// first segment and do not give the length of Data.
+type Unit struct {
+	Packet
+	Count int
+	Last  Packet
+}
+
+// SegmentError reports the segments that have been discarded by a
+// Reassembler.
+type SegmentError struct {
+	Apid  uint16
+	First uint16
+	Last  uint16
+	Count int
+	Err   error
+}
+
+func (e SegmentError) Error() string {
+	return fmt.Sprintf("apid %d: %d segment(s) dropped (%d-%d): %s", e.Apid, e.Count, e.First, e.Last, e.Err)
+}
+
+func (e SegmentError) Unwrap() error {
+	return e.Err
+}
+
+func (u *Unit) error(err error) error {
+	return SegmentError{
+		Apid:  u.Apid(),
+		First: u.Sequence(),
+		Last:  u.Last.Sequence(),
+		Count: u.Count,
+		Err:   err,
+	}
+}
+
+// Reassembler joins the segments of the packets of each apid into complete
+// units. A group of segments is discarded when one of its segments is missing
+// (according to the sequence counter) or when the time between two of its
+// segments is greater than the timeout of the Reassembler.
+type Reassembler struct {
+	timeout time.Duration
+	groups  map[uint16]*Unit
+}
+
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		timeout: timeout,
+		groups:  make(map[uint16]*Unit),
+	}
+}
+
+// Push gives the next packet to the Reassembler. It returns a Unit when the
+// packet completes one. The error reports the segments that have been
+// discarded: the incomplete group interrupted by p and p itself when it is a
+// segment without group (both SegmentError joined with errors.Join). It does
+// not prevent a Unit to be returned.
+func (r *Reassembler) Push(p Packet) (Unit, bool, error) {
+	var (
+		err   error
+		apid  = p.Apid()
+		seg   = p.Segmentation()
+		g, ok = r.groups[apid]
+	)
+	if ok && (seg == segFirst || seg == segUnsegmented || !r.follow(g, p)) {
+		delete(r.groups, apid)
+		err, ok = g.error(ErrIncomplete), false
+	}
+	switch seg {
+	case segUnsegmented:
+		return Unit{Packet: p, Count: 1, Last: p}, true, err
+	case segFirst:
+		g = &Unit{Packet: p, Count: 1, Last: p}
+		g.Data = append([]byte(nil), p.Data...)
+		r.groups[apid] = g
+		return Unit{}, false, err
+	}
+	if !ok {
+		var orphan error = SegmentError{
+			Apid:  apid,
+			First: p.Sequence(),
+			Last:  p.Sequence(),
+			Count: 1,
+			Err:   ErrOrphan,
+		}
+		if err != nil {
+			orphan = errors.Join(err, orphan)
+		}
+		return Unit{}, false, orphan
+	}
+	g.Count++
+	g.Last = p
+	g.Data = append(g.Data, p.Data...)
+	if seg != segLast {
+		return Unit{}, false, nil
+	}
+	delete(r.groups, apid)
+	return *g, true, nil
+}
+
+// Expire discards the groups of segments whose last segment is older than the
+// given time minus the timeout of the Reassembler.
+func (r *Reassembler) Expire(now time.Time) []error {
+	if r.timeout <= 0 {
+		return nil
+	}
+	var es []error
+	for apid, g := range r.groups {
+		if now.Sub(g.Last.Timestamp()) > r.timeout {
+			delete(r.groups, apid)
+			es = append(es, g.error(ErrIncomplete))
+		}
+	}
+	return es
+}
+
+// Flush discards all the groups of segments not yet completed.
+func (r *Reassembler) Flush() []error {
+	var es []error
+	for apid, g := range r.groups {
+		delete(r.groups, apid)
+		es = append(es, g.error(ErrIncomplete))
+	}
+	return es
+}
+
+// follow reports whether p is the segment coming just after the last segment
+// of g.
+func (r *Reassembler) follow(g *Unit, p Packet) bool {
+	if (g.Last.Sequence()+1)&0x3FFF != p.Sequence() {
+		return false
+	}
+	return r.timeout <= 0 || p.Timestamp().Sub(g.Last.Timestamp()) <= r.timeout
+}
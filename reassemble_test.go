@@ -0,0 +1,137 @@
+package pathtm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReassembler(t *testing.T) {
+	type push struct {
+		Apid     uint16
+		Segment  CCSDSSegment
+		Sequence uint16
+		Time     int
+		// count of segments of the unit completed by the packet (0 when
+		// no unit is completed)
+		Count int
+		// errors reported by Push
+		Errs []error
+	}
+	data := []struct {
+		Name   string
+		Pushes []push
+	}{
+		{
+			Name: "unsegmented",
+			Pushes: []push{
+				{Apid: 1, Segment: segUnsegmented, Sequence: 1, Count: 1},
+				{Apid: 1, Segment: segUnsegmented, Sequence: 2, Count: 1},
+			},
+		},
+		{
+			Name: "segmented",
+			Pushes: []push{
+				{Apid: 1, Segment: segFirst, Sequence: 16382},
+				{Apid: 2, Segment: segFirst, Sequence: 10},
+				{Apid: 1, Segment: segContinuation, Sequence: 16383},
+				{Apid: 2, Segment: segLast, Sequence: 11, Count: 2},
+				{Apid: 1, Segment: segLast, Sequence: 0, Count: 3},
+			},
+		},
+		{
+			Name: "missing",
+			Pushes: []push{
+				{Apid: 1, Segment: segFirst, Sequence: 1},
+				{Apid: 1, Segment: segContinuation, Sequence: 3, Errs: []error{ErrIncomplete, ErrOrphan}},
+				{Apid: 1, Segment: segLast, Sequence: 4, Errs: []error{ErrOrphan}},
+			},
+		},
+		{
+			Name: "interrupted",
+			Pushes: []push{
+				{Apid: 1, Segment: segFirst, Sequence: 1},
+				{Apid: 1, Segment: segFirst, Sequence: 2, Errs: []error{ErrIncomplete}},
+				{Apid: 1, Segment: segUnsegmented, Sequence: 3, Count: 1, Errs: []error{ErrIncomplete}},
+			},
+		},
+		{
+			Name: "timeout",
+			Pushes: []push{
+				{Apid: 1, Segment: segFirst, Sequence: 1, Time: 0},
+				{Apid: 1, Segment: segLast, Sequence: 2, Time: 10, Errs: []error{ErrIncomplete, ErrOrphan}},
+			},
+		},
+	}
+	for _, d := range data {
+		r := NewReassembler(5 * time.Second)
+		for i, s := range d.Pushes {
+			p := testSegment(s.Apid, s.Segment, s.Sequence, s.Time)
+			u, ok, err := r.Push(p)
+			if ok != (s.Count > 0) || (ok && u.Count != s.Count) {
+				t.Errorf("%s: push %d: unit mismatched: want %d segments, got %t (%d segments)", d.Name, i+1, s.Count, ok, u.Count)
+			}
+			if len(s.Errs) == 0 && err != nil {
+				t.Errorf("%s: push %d: unexpected error: %s", d.Name, i+1, err)
+			}
+			for _, e := range s.Errs {
+				if !errors.Is(err, e) {
+					t.Errorf("%s: push %d: expected %s, got %v", d.Name, i+1, e, err)
+				}
+			}
+			if !ok {
+				continue
+			}
+			var want []byte
+			for j := s.Count - 1; j >= 0; j-- {
+				want = append(want, testSegment(s.Apid, 0, s.Sequence-uint16(j), 0).Data...)
+			}
+			if !bytes.Equal(u.Data, want) {
+				t.Errorf("%s: push %d: data mismatched", d.Name, i+1)
+			}
+			if u.Last.Sequence() != s.Sequence || u.Sequence() != (s.Sequence-uint16(s.Count-1))&0x3FFF {
+				t.Errorf("%s: push %d: segments mismatched: want %d-%d, got %d-%d", d.Name, i+1, s.Sequence-uint16(s.Count-1), s.Sequence, u.Sequence(), u.Last.Sequence())
+			}
+			if u.CCSDSHeader.Length != p.Length {
+				t.Errorf("%s: push %d: length of the first segment not kept", d.Name, i+1)
+			}
+		}
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	r := NewReassembler(5 * time.Second)
+	r.Push(testSegment(1, segFirst, 1, 0))
+	r.Push(testSegment(2, segFirst, 1, 4))
+	r.Push(testSegment(2, segContinuation, 2, 8))
+
+	es := r.Expire(testSegment(1, 0, 0, 10).Timestamp())
+	if len(es) != 1 {
+		t.Fatalf("expired groups mismatched: want 1, got %d", len(es))
+	}
+	var e SegmentError
+	if !errors.As(es[0], &e) || e.Apid != 1 || e.Count != 1 || !errors.Is(e, ErrIncomplete) {
+		t.Errorf("expired group mismatched: got %v", es[0])
+	}
+	es = r.Flush()
+	if len(es) != 1 || !errors.As(es[0], &e) || e.Apid != 2 || e.First != 1 || e.Last != 2 || e.Count != 2 {
+		t.Errorf("flushed groups mismatched: got %v", es)
+	}
+	if es := r.Flush(); len(es) != 0 {
+		t.Errorf("groups left after flush: %v", es)
+	}
+}
+
+// testSegment gives a segment of apid generated at the given second. Its data
+// depends on its sequence counter.
+func testSegment(apid uint16, seg CCSDSSegment, seq uint16, when int) Packet {
+	p := testPacket(apid, true, 8)
+	p.Fragment = uint16(seg)<<14 | seq&0x3FFF
+	for i := range p.Data {
+		p.Data[i] = byte(seq) + byte(i)
+	}
+	t := time.Date(2020, 1, 1, 0, 0, when, 0, time.UTC)
+	p.ESAHeader.Coarse, p.ESAHeader.Fine = SplitTime(t)
+	return p
+}
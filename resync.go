@@ -0,0 +1,121 @@
+package pathtm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrLength = errors.New("inconsistent packet length")
+	ErrApid   = errors.New("apid out of range")
+	ErrTime   = errors.New("packet time out of order")
+)
+
+// SkipError describes a range of bytes that a Decoder has skipped while
+// looking for the next valid packet. As for DecodeError, File is only set when
+// the reader of the Decoder reports the file being read and Offset is then the
+// position of the first skipped byte in this file.
+type SkipError struct {
+	File   string
+	Offset int64
+	Length int
+	Err    error
+}
+
+func (e SkipError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s: %d bytes skipped at offset %d: %s", e.File, e.Length, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%d bytes skipped at offset %d: %s", e.Length, e.Offset, e.Err)
+}
+
+func (e SkipError) Unwrap() error {
+	return e.Err
+}
+
+// Resync makes the decoder scan forward for the next plausible packet instead
+// of failing when the data in the stream is corrupted. A packet is plausible
+// when its version is 0, its apid is in the range [first, last], its PTH size
+// matches the length of its CCSDS header and its PTH time is not before the
+// time of the last valid packet. Each range of skipped bytes is given to fn.
+func (d *Decoder) Resync(first, last uint16, fn func(SkipError)) {
+	if fn == nil {
+		fn = func(_ SkipError) {}
+	}
+	d.resync = &resync{
+		first: first,
+		last:  last,
+		skip:  fn,
+	}
+}
+
+type resync struct {
+	first uint16
+	last  uint16
+	when  time.Time
+	skip  func(SkipError)
+}
+
+func (r *resync) check(header []byte, size int, framing Framing, scan bool) error {
+	if framing != FramePTH {
+		c, err := decodeCCSDS(header)
+		if err != nil {
+			return err
+		}
+		return r.checkApid(c)
+	}
+	h, err := decodePTH(header)
+	if err != nil {
+		return err
+	}
+	c, err := decodeCCSDS(header[PTHHeaderLen:])
+	if err != nil {
+		return err
+	}
+	if size != PTHHeaderLen+CCSDSHeaderLen+c.bodyLen() {
+		return ErrLength
+	}
+	if err := r.checkApid(c); err != nil {
+		return err
+	}
+	if scan && h.Timestamp().Before(r.when) {
+		return ErrTime
+	}
+	return nil
+}
+
+func (r *resync) checkApid(c CCSDSHeader) error {
+	if pid := c.Apid(); pid < r.first || pid > r.last {
+		return ErrApid
+	}
+	return nil
+}
+
+func (r *resync) accept(body []byte, framing Framing) {
+	if framing != FramePTH {
+		return
+	}
+	if h, err := decodePTH(body); err == nil {
+		r.when = h.Timestamp()
+	}
+}
+
+func (r *resync) skipped(file string, offset int64, n int, err error) {
+	r.skip(SkipError{
+		File:   file,
+		Offset: offset,
+		Length: n,
+		Err:    err,
+	})
+}
+
+func isCorrupted(err error) bool {
+	switch err {
+	case ErrVersion, ErrLength, ErrApid, ErrTime, ErrTooLarge, io.ErrShortBuffer:
+		return true
+	default:
+		return false
+	}
+}
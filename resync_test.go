@@ -0,0 +1,119 @@
+package pathtm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestResync(t *testing.T) {
+	var (
+		first  = testStream(t, 3, 16)
+		second = testStream(t, 3, 16)
+		size   = len(second) / 3
+		junk   = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	)
+	// junk between the 1st and the 2nd packet of the second file and in
+	// front of the first file
+	second = append(append(second[:size:size], junk...), second[size:]...)
+	first = append(append([]byte(nil), junk[:3]...), first...)
+
+	r := multiReader{
+		names: []string{"a.dat", "b.dat"},
+		files: [][]byte{first, second},
+	}
+	var skipped []SkipError
+	d := NewDecoder(&r, nil)
+	d.Resync(0, 10, func(e SkipError) {
+		skipped = append(skipped, e)
+	})
+
+	var offsets []int64
+	for {
+		_, err := d.Decode(true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		offsets = append(offsets, d.Offset())
+	}
+	var (
+		base = int64(len(first))
+		skip = int64(len(junk))
+		step = int64(size)
+		want = []int64{3, 3 + step, 3 + 2*step, base, base + step + skip, base + 2*step + skip}
+	)
+	if len(offsets) != len(want) {
+		t.Fatalf("packets count mismatched: want %d, got %d", len(want), len(offsets))
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("packet %d: offset mismatched: want %d, got %d", i+1, want[i], offsets[i])
+		}
+	}
+
+	wantSkip := []SkipError{
+		{File: "a.dat", Offset: 0, Length: 3},
+		{File: "b.dat", Offset: int64(size), Length: len(junk)},
+	}
+	if len(skipped) != len(wantSkip) {
+		t.Fatalf("skipped ranges mismatched: want %d, got %d", len(wantSkip), len(skipped))
+	}
+	for i, w := range wantSkip {
+		s := skipped[i]
+		if s.File != w.File || s.Offset != w.Offset || s.Length != w.Length {
+			t.Errorf("skipped range %d mismatched: want %s at %d (%d bytes), got %s", i+1, w.File, w.Offset, w.Length, s)
+		}
+		if !errors.Is(s, ErrTooLarge) {
+			t.Errorf("skipped range %d: unexpected cause: %s", i+1, s.Err)
+		}
+	}
+}
+
+func TestResyncTime(t *testing.T) {
+	var (
+		buf  []byte
+		junk = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	)
+	older := testPacket(1, true, 16)
+	older.PTHHeader.Coarse -= 10
+
+	// a packet older than the previous one is only rejected when the
+	// decoder is looking for the next valid packet after junk.
+	for i, p := range []Packet{testPacket(0, true, 16), older, testPacket(2, true, 16), older, testPacket(4, true, 16)} {
+		if i == 3 {
+			buf = append(buf, junk...)
+		}
+		var err error
+		if buf, err = p.MarshalAppend(buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	var skipped []SkipError
+	d := NewDecoder(bytes.NewReader(buf), nil)
+	d.Resync(0, 10, func(e SkipError) {
+		skipped = append(skipped, e)
+	})
+
+	var apids []uint16
+	for {
+		p, err := d.Decode(true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		apids = append(apids, p.Apid())
+	}
+	if len(apids) != 4 || apids[0] != 0 || apids[1] != 1 || apids[2] != 2 || apids[3] != 4 {
+		t.Errorf("packets mismatched: want [0 1 2 4], got %v", apids)
+	}
+	size := (len(buf) - len(junk)) / 5
+	if len(skipped) != 1 || skipped[0].Offset != int64(3*size) || skipped[0].Length != len(junk)+size {
+		t.Fatalf("skipped ranges mismatched: want %d bytes at %d, got %v", len(junk)+size, 3*size, skipped)
+	}
+}